@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"greenlight.aslan/internal/ratelimit"
+	"strconv"
+	"time"
+)
+
+func (app *application) cleanupExpiredDataJob(ctx context.Context, payload struct{}) error {
+	now := time.Now()
+
+	type cleanup struct {
+		name    string
+		cleanup func() (int64, error)
+	}
+
+	cleanups := []cleanup{
+		{"tokens", func() (int64, error) { return app.models.Tokens.DeleteExpired(ctx, now) }},
+		{"revoked_tokens", func() (int64, error) { return app.models.RevokedTokens.DeleteExpired(ctx, now) }},
+		{"data_exports", func() (int64, error) { return app.models.Exports.DeleteExpired(ctx, now) }},
+		{"email_outbox", func() (int64, error) {
+			return app.models.Outbox.DeleteFinished(ctx, now.Add(-app.config.outbox.retention))
+		}},
+		{"jobs", func() (int64, error) { return app.models.Jobs.DeleteFinished(ctx, now.Add(-app.config.jobs.retention)) }},
+		{"webhook_deliveries", func() (int64, error) {
+			return app.models.Webhooks.DeleteDeliveries(ctx, now.Add(-app.config.webhooks.deliveryRetention))
+		}},
+		{"events", func() (int64, error) {
+			return app.models.Events.DeleteBefore(ctx, now.Add(-app.config.events.retention))
+		}},
+		{"oauth_states", func() (int64, error) { return app.models.Identities.DeleteExpiredStates(ctx, now) }},
+		{"login_attempts", func() (int64, error) {
+			return app.models.LoginAttempts.DeleteStale(ctx, now.Add(-app.config.login.window), now)
+		}},
+	}
+
+	// Shared rate limit buckets idle for a day are full again by then for any
+	// sensible limit.
+	if limiter, ok := app.limiter.(*ratelimit.Postgres); ok {
+		cleanups = append(cleanups, cleanup{"rate_limits", func() (int64, error) { return limiter.DeleteIdle(ctx, now.Add(-24*time.Hour)) }})
+	}
+
+	properties := map[string]string{}
+
+	for _, c := range cleanups {
+		count, err := c.cleanup()
+		if err != nil {
+			return err
+		}
+
+		properties[c.name] = strconv.FormatInt(count, 10)
+	}
+
+	app.logger.PrintInfo("deleted expired data", properties)
+
+	return nil
+}
+
+func (app *application) cleanupUnactivatedUsersJob(ctx context.Context, payload struct{}) error {
+	count, err := app.models.Users.DeleteUnactivated(ctx, time.Now().Add(-app.config.cleanup.unactivatedMaxAge))
+	if err != nil {
+		return err
+	}
+
+	app.logger.PrintInfo("deleted unactivated user accounts", map[string]string{
+		"count": strconv.FormatInt(count, 10),
+	})
+
+	return nil
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/validator"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// command is a subcommand of the greenlight binary. Names have one or two
+// words, such as "serve" or "user create".
+type command struct {
+	name  string
+	args  string
+	desc  string
+	db    bool
+	flags func(fs *flag.FlagSet)
+	run   func(c *cli, args []string) error
+}
+
+// cli is what a command runs against. Commands with db set get a connected
+// db and app.models, the others only the config and the logger. args holds
+// the command line the configuration was loaded from.
+type cli struct {
+	app    *application
+	db     *sql.DB
+	args   []string
+	flags  *flag.FlagSet
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func commands() []command {
+	var (
+		name     string
+		email    string
+		password string
+		admin    bool
+	)
+
+	return []command{
+		{
+			name: "serve",
+			desc: "Run the API server (the default)",
+			db:   true,
+			run:  runServe,
+		},
+		{
+			name: "migrate",
+			args: "up | down [N] | status | goto N",
+			desc: "Apply, roll back or show database migrations",
+			db:   true,
+			run: func(c *cli, args []string) error {
+				return runMigrate(c.db, c.app.logger, c.stdout, args)
+			},
+		},
+		{
+			name: "user create",
+			desc: "Create an activated user, reading the password from stdin unless -password is set",
+			db:   true,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&name, "name", "", "Name of the user")
+				fs.StringVar(&email, "email", "", "Email address of the user")
+				fs.StringVar(&password, "password", "", "Password of the user (read from stdin when empty)")
+				fs.BoolVar(&admin, "admin", false, "Give the user the admin role")
+			},
+			run: func(c *cli, args []string) error {
+				if len(args) > 0 {
+					return fmt.Errorf("user create takes no arguments, got %q", args)
+				}
+				return c.createUser(name, email, password, admin)
+			},
+		},
+		{
+			name: "user activate",
+			args: "<email>",
+			desc: "Activate a user without an activation token",
+			db:   true,
+			run: func(c *cli, args []string) error {
+				if len(args) != 1 {
+					return errors.New("usage: greenlight user activate <email>")
+				}
+				return c.activateUser(args[0])
+			},
+		},
+		{
+			name: "user reset-password",
+			args: "<email>",
+			desc: "Set a new password, reading it from stdin unless -password is set, and sign the user out",
+			db:   true,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&password, "password", "", "New password (read from stdin when empty)")
+			},
+			run: func(c *cli, args []string) error {
+				if len(args) != 1 {
+					return errors.New("usage: greenlight user reset-password <email>")
+				}
+				return c.resetPassword(args[0], password)
+			},
+		},
+		{
+			name: "token purge",
+			desc: "Delete expired tokens, or every token of one user with -email",
+			db:   true,
+			flags: func(fs *flag.FlagSet) {
+				fs.StringVar(&email, "email", "", "Delete all activation and authentication tokens of this user instead")
+			},
+			run: func(c *cli, args []string) error {
+				if len(args) > 0 {
+					return fmt.Errorf("token purge takes no arguments, got %q", args)
+				}
+				return c.purgeTokens(email)
+			},
+		},
+		{
+			name: "movies import",
+			args: "<file>",
+			desc: "Insert the movies in a JSON array, read from stdin when the file is -",
+			db:   true,
+			run: func(c *cli, args []string) error {
+				if len(args) != 1 {
+					return errors.New("usage: greenlight movies import <file>")
+				}
+				return c.importMovies(args[0])
+			},
+		},
+		{
+			name: "movies export",
+			desc: "Write every movie to stdout as a JSON array",
+			db:   true,
+			run: func(c *cli, args []string) error {
+				if len(args) > 0 {
+					return fmt.Errorf("movies export takes no arguments, got %q", args)
+				}
+				return c.exportMovies()
+			},
+		},
+		{
+			name: "config print",
+			desc: "Print the configuration with secrets redacted",
+			run: func(c *cli, args []string) error {
+				c.printConfig()
+				return nil
+			},
+		},
+		{
+			name: "help",
+			desc: "Show this help",
+			run: func(c *cli, args []string) error {
+				fmt.Fprint(c.stdout, usage(commands()))
+				return nil
+			},
+		},
+	}
+}
+
+// findCommand picks the command named by the leading words of args and
+// returns the rest. Without a command name it falls back to serve, so that
+// invocations with only flags keep working.
+func findCommand(cmds []command, args []string) (command, []string, error) {
+	lookup := func(name string) (command, bool) {
+		for _, cmd := range cmds {
+			if cmd.name == name {
+				return cmd, true
+			}
+		}
+		return command{}, false
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		cmd, _ := lookup("serve")
+		return cmd, args, nil
+	}
+
+	if len(args) > 1 {
+		if cmd, ok := lookup(args[0] + " " + args[1]); ok {
+			return cmd, args[2:], nil
+		}
+	}
+
+	if cmd, ok := lookup(args[0]); ok {
+		return cmd, args[1:], nil
+	}
+
+	if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+		return command{}, nil, fmt.Errorf("unknown command %q", args[0]+" "+args[1])
+	}
+
+	return command{}, nil, fmt.Errorf("unknown command %q", args[0])
+}
+
+// parseInterspersed parses fs from args, allowing flags to come after
+// positional arguments, and returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func usage(cmds []command) string {
+	var b strings.Builder
+
+	b.WriteString("usage: greenlight [command] [flags]\n\ncommands:\n")
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	for _, cmd := range cmds {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.desc)
+	}
+	tw.Flush()
+
+	b.WriteString("\nRun greenlight <command> -h to list the flags of a command.\n")
+
+	return b.String()
+}
+
+func (c *cli) createUser(name, email, password string, admin bool) error {
+	if password == "" {
+		var err error
+		password, err = c.readLine()
+		if err != nil {
+			return err
+		}
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     email,
+		Role:      "user",
+		Activated: true,
+	}
+
+	if admin {
+		user.Role = "admin"
+	}
+
+	err := user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	v := validator.New()
+	if data.ValidateUser(v, user); !v.Valid() {
+		return validationError(v)
+	}
+
+	err = c.app.models.Users.Insert(context.Background(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			return errors.New("a user with this email address already exists")
+		default:
+			return err
+		}
+	}
+
+	return c.printJSON(envelope{"user": user})
+}
+
+func (c *cli) activateUser(email string) error {
+	ctx := context.Background()
+
+	user, err := c.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	if user.Activated {
+		fmt.Fprintf(c.stdout, "user %s is already activated\n", user.Email)
+		return nil
+	}
+
+	user.Activated = true
+
+	err = c.app.models.Transaction(ctx, func(tx data.Models) error {
+		err := tx.Users.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// Running instances pick the revoked JWTs up on their next denylist
+		// refresh.
+		_, err = tx.RevokedTokens.RevokeAllForUser(ctx, user.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stdout, "activated user %s\n", user.Email)
+
+	return nil
+}
+
+func (c *cli) resetPassword(email, password string) error {
+	ctx := context.Background()
+
+	user, err := c.getUser(email)
+	if err != nil {
+		return err
+	}
+
+	if password == "" {
+		password, err = c.readLine()
+		if err != nil {
+			return err
+		}
+	}
+
+	v := validator.New()
+	if data.ValidatePasswordPlaintext(v, password); !v.Valid() {
+		return validationError(v)
+	}
+
+	err = user.Password.Set(password)
+	if err != nil {
+		return err
+	}
+
+	err = c.app.models.Transaction(ctx, func(tx data.Models) error {
+		err := tx.Users.Update(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		err = tx.APIKeys.DeleteAllForUser(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		// Running instances pick the revoked JWTs up on their next denylist
+		// refresh.
+		_, err = revokeUserTokens(ctx, tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stdout, "reset the password of user %s\n", user.Email)
+
+	return nil
+}
+
+func (c *cli) purgeTokens(email string) error {
+	ctx := context.Background()
+
+	if email != "" {
+		user, err := c.getUser(email)
+		if err != nil {
+			return err
+		}
+
+		err = c.app.models.Transaction(ctx, func(tx data.Models) error {
+			for _, scope := range []string{data.ScopeActivation, data.ScopeAuthentication} {
+				err := tx.Tokens.DeleteAllForUser(ctx, scope, user.ID)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(c.stdout, "deleted the tokens of user %s\n", user.Email)
+
+		return nil
+	}
+
+	now := time.Now()
+
+	tokens, err := c.app.models.Tokens.DeleteExpired(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := c.app.models.RevokedTokens.DeleteExpired(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stdout, "deleted %d expired tokens and %d expired revoked tokens\n", tokens, revoked)
+
+	return nil
+}
+
+// importMovies reads the same JSON array movies export writes. Fields the API
+// sets itself, such as id and version, are ignored. Nothing is inserted
+// unless every movie is valid.
+func (c *cli) importMovies(file string) error {
+	ctx := context.Background()
+
+	var r io.Reader = c.stdin
+
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	var input []struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+	}
+
+	err := json.NewDecoder(r).Decode(&input)
+	if err != nil {
+		return fmt.Errorf("reading movies: %w", err)
+	}
+
+	movies := make([]*data.Movie, len(input))
+
+	for i, in := range input {
+		movie := &data.Movie{
+			Title:   in.Title,
+			Year:    in.Year,
+			Runtime: in.Runtime,
+			Genres:  in.Genres,
+		}
+
+		v := validator.New()
+		if data.ValidateMovie(v, movie); !v.Valid() {
+			return fmt.Errorf("movie %d: %w", i, validationError(v))
+		}
+
+		movies[i] = movie
+	}
+
+	err = c.app.models.Transaction(ctx, func(tx data.Models) error {
+		for _, movie := range movies {
+			err := tx.Movies.Insert(ctx, movie)
+			if err != nil {
+				return err
+			}
+
+			err = c.app.enqueueWebhooks(ctx, tx, data.EventMovieCreated, envelope{"movie": movie})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stdout, "imported %d movies\n", len(movies))
+
+	return nil
+}
+
+func (c *cli) exportMovies() error {
+	ctx := context.Background()
+
+	movies := []*data.Movie{}
+
+	filters := data.Filters{Page: 1, PageSize: 100, Sort: "id", SortSafelist: []string{"id"}}
+
+	for {
+		page, metadata, err := c.app.models.Movies.GetAll(ctx, "", []string{}, filters)
+		if err != nil {
+			return err
+		}
+
+		movies = append(movies, page...)
+
+		if filters.Page >= metadata.LastPage {
+			break
+		}
+
+		filters.Page++
+	}
+
+	return c.printJSON(movies)
+}
+
+// printConfig prints every flag as name=value, sorted by name, with secrets
+// redacted.
+func (c *cli) printConfig() {
+	c.flags.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(c.stdout, "%s=%s\n", f.Name, redactSetting(f.Name, f.Value.String()))
+	})
+}
+
+func (c *cli) getUser(email string) (*data.User, error) {
+	user, err := c.app.models.Users.GetByEmail(context.Background(), email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil, fmt.Errorf("no user with email address %q", email)
+		default:
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// readLine reads a single line from stdin, so that passwords can be piped in
+// instead of showing up in the shell history and process list.
+func (c *cli) readLine() (string, error) {
+	line, err := bufio.NewReader(c.stdin).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("reading password from stdin: %w", err)
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "\t")
+	return enc.Encode(v)
+}
+
+// validationError turns the errors of a validator into a single error, with
+// the fields sorted so the message is stable.
+func validationError(v *validator.Validator) error {
+	fields := make([]string, 0, len(v.Errors))
+	for field := range v.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = field + " " + v.Errors[field]
+	}
+
+	return errors.New(strings.Join(messages, ", "))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const clientIPContextKey = contextKey("clientIP")
+
+// prefixList is a flag.Value holding comma-separated CIDRs. Plain addresses
+// are taken as single-address prefixes.
+type prefixList []netip.Prefix
+
+func (l *prefixList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	prefixes := make([]string, len(*l))
+	for i, prefix := range *l {
+		prefixes[i] = prefix.String()
+	}
+
+	return strings.Join(prefixes, ",")
+}
+
+func (l *prefixList) Set(value string) error {
+	prefixes := prefixList{}
+
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return fmt.Errorf("invalid address %q", field)
+			}
+
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", field)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	*l = prefixes
+	return nil
+}
+
+func (l prefixList) contains(addr netip.Addr) bool {
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP stores the address of the client in the request context,
+// for the rate limiter, the login throttling and the access log.
+func (app *application) resolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r, app.config.trustedProxies)
+
+		ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// contextGetClientIP returns the address stored by resolveClientIP, falling
+// back to the address of the peer for requests that didn't pass through it.
+func (app *application) contextGetClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+
+	return remoteIP(r)
+}
+
+// clientIP returns the address of the client that made r. The Forwarded,
+// X-Forwarded-For and X-Real-IP headers, in that order of preference, are
+// only believed when the request comes from a trusted proxy. The forwarding
+// chain is read from the right, skipping trusted proxies, so that clients
+// can't pick their address by sending the headers themselves.
+func clientIP(r *http.Request, trusted prefixList) string {
+	peer, ok := parseHop(r.RemoteAddr)
+	if !ok {
+		return remoteIP(r)
+	}
+
+	if !trusted.contains(peer) {
+		return peer.String()
+	}
+
+	hops := forwardedHops(r.Header)
+
+	if hops == nil {
+		if realIP, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+			return realIP.String()
+		}
+		return peer.String()
+	}
+
+	client := peer
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseHop(hops[i])
+		if !ok {
+			// Obfuscated or unknown hops end what can be known about the
+			// chain, so the last proxy is the best guess left.
+			break
+		}
+
+		client = addr
+
+		if !trusted.contains(addr) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// forwardedHops returns the forwarding chain, nearest the client first, from
+// the RFC 7239 Forwarded header or else X-Forwarded-For. Header lines are
+// combined in order, as a proxy may append a line instead of a list item.
+func forwardedHops(h http.Header) []string {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		hops := []string{}
+
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			hop := ""
+
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(key, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+
+			hops = append(hops, hop)
+		}
+
+		return hops
+	}
+
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		return strings.Split(strings.Join(values, ","), ",")
+	}
+
+	return nil
+}
+
+// parseHop parses an address as found in RemoteAddr and forwarding headers,
+// with or without a port and with IPv6 addresses optionally in brackets.
+func parseHop(hop string) (netip.Addr, bool) {
+	hop = strings.TrimSpace(hop)
+
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"greenlight.aslan/internal/configfile"
+	"greenlight.aslan/internal/cron"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/jsonlog"
+	"greenlight.aslan/internal/jwt"
+	"greenlight.aslan/internal/oauth"
+	"greenlight.aslan/internal/validator"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// repeatableSettings are the flags that may be given more than once. A list
+// in the config file sets them once per item, while lists for other settings
+// are joined with commas.
+var repeatableSettings = map[string]bool{"oauth-provider": true, "limiter-policy": true}
+
+var dsnPasswordRX = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// defineConfigFlags defines a flag on fs for every setting, storing the
+// values in cfg.
+func defineConfigFlags(fs *flag.FlagSet, cfg *config) {
+	fs.StringVar(&cfg.file, "config", "", "TOML or YAML file with settings, overridden by GREENLIGHT_* environment variables and flags")
+	fs.StringVar(&cfg.logLevel, "log-level", "info", "Minimum level of log entries (info|error|fatal|off)")
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	fs.StringVar(&cfg.baseURL, "base-url", "http://localhost:4000", "Public base URL used in emailed links")
+	fs.DurationVar(&cfg.drainDelay, "drain-delay", 5*time.Second, "Time between failing readiness probes and closing the listener on shutdown, for load balancers to stop sending requests")
+	fs.Var(&cfg.trustedProxies, "trusted-proxies", "Comma-separated CIDRs of proxies trusted to set the Forwarded, X-Forwarded-For and X-Real-IP headers")
+
+	fs.StringVar(&cfg.db.dsn, "db_dsn", "", "PostgresSQL DSN")
+
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", data.DefaultQueryTimeout, "Timeout for a single database query")
+	fs.BoolVar(&cfg.db.autoMigrate, "auto-migrate", false, "Apply pending database migrations on startup")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.Float64Var(&cfg.limiter.authFailureRPS, "limiter-auth-failure-rps", 0.1, "Maximum failed authentication attempts per second from an IP address")
+	fs.IntVar(&cfg.limiter.authFailureBurst, "limiter-auth-failure-burst", 5, "Maximum burst of failed authentication attempts from an IP address")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.StringVar(&cfg.limiter.store, "limiter-store", "memory", "Where rate limiter buckets are kept (memory|postgres), postgres shares them between instances")
+	fs.StringVar(&cfg.limiter.exemptRoles, "limiter-exempt-roles", "admin", "Comma-separated roles whose users are not rate limited")
+	fs.Func("limiter-policy", "Rate limit for matching requests as route=[METHOD ]/pattern,principal=anonymous|user|role:<name>,rps=...,burst=... (repeatable, the first match applies)", func(value string) error {
+		policy, err := parseRateLimitPolicy(value)
+		if err != nil {
+			return err
+		}
+
+		cfg.limiter.policies = append(cfg.limiter.policies, policy)
+		return nil
+	})
+
+	fs.IntVar(&cfg.login.maxAttempts, "login-max-attempts", 5, "Failed logins per email before the account is locked (0 to disable)")
+	fs.IntVar(&cfg.login.ipMaxAttempts, "login-ip-max-attempts", 20, "Failed logins per IP before further attempts are rejected (0 to disable)")
+	fs.DurationVar(&cfg.login.window, "login-window", 15*time.Minute, "Window in which failed logins are counted")
+	fs.DurationVar(&cfg.login.lockout, "login-lockout", 30*time.Minute, "Account lockout duration")
+	fs.DurationVar(&cfg.login.delay, "login-delay", 250*time.Millisecond, "Base delay added after a failed login, doubled on every further failure")
+	fs.DurationVar(&cfg.login.maxDelay, "login-max-delay", 5*time.Second, "Maximum delay added after failed logins")
+
+	fs.StringVar(&cfg.token.mode, "token-mode", "opaque", "Authentication token format (opaque|jwt)")
+	fs.DurationVar(&cfg.token.ttl, "token-ttl", 24*time.Hour, "Authentication token lifetime")
+	fs.StringVar(&cfg.token.jwt.algorithm, "jwt-alg", jwt.AlgorithmHS256, "JWT signing algorithm (HS256|EdDSA)")
+	fs.StringVar(&cfg.token.jwt.keys, "jwt-keys", "", "Comma-separated kid:path JWT key files, the first one signs new tokens")
+	fs.StringVar(&cfg.token.jwt.issuer, "jwt-issuer", "greenlight", "JWT issuer")
+	fs.DurationVar(&cfg.token.jwt.denylistRefresh, "jwt-denylist-refresh", 30*time.Second, "Interval for reloading revoked JWTs from the database")
+
+	fs.StringVar(&cfg.oauth.configFile, "oauth-config", "", "JSON file with OAuth2/OpenID Connect provider settings")
+	fs.Func("oauth-provider", "OAuth2/OpenID Connect provider as name=...,issuer=...,client-id=...,client-secret=...,redirect-url=... (repeatable)", func(value string) error {
+		providerConfig, err := oauth.ParseConfig(value)
+		if err != nil {
+			return err
+		}
+
+		cfg.oauth.providers = append(cfg.oauth.providers, providerConfig)
+		return nil
+	})
+
+	fs.DurationVar(&cfg.gdpr.exportTTL, "export-ttl", 24*time.Hour, "How long a data export download link stays valid")
+	fs.DurationVar(&cfg.gdpr.deletionGrace, "deletion-grace", 30*24*time.Hour, "Grace period before a deleted account is purged")
+	fs.StringVar(&cfg.gdpr.purgeSchedule, "deletion-purge-schedule", "@hourly", "Cron schedule for purging accounts whose deletion grace period is over")
+
+	fs.StringVar(&cfg.mailer.backend, "mailer", "smtp", "Mailer backend (smtp|file|log|memory)")
+	fs.StringVar(&cfg.mailer.dir, "mailer-dir", "tmp/mail", "Directory the file mailer writes .eml files to")
+	fs.StringVar(&cfg.mailer.templates, "mailer-templates", "", "Directory of email templates overriding the embedded ones")
+
+	fs.DurationVar(&cfg.cleanup.interval, "cleanup-interval", time.Hour, "Interval for deleting expired tokens and other stale data (0 disables)")
+	fs.DurationVar(&cfg.cleanup.unactivatedInterval, "cleanup-unactivated-interval", 24*time.Hour, "Interval for deleting old unactivated accounts (0 disables)")
+	fs.DurationVar(&cfg.cleanup.unactivatedMaxAge, "unactivated-max-age", 7*24*time.Hour, "Age after which unactivated accounts are deleted")
+
+	fs.IntVar(&cfg.jobs.workers, "job-workers", 2, "Number of background job workers")
+	fs.DurationVar(&cfg.jobs.pollInterval, "job-poll-interval", time.Second, "Interval between job queue polls")
+	fs.IntVar(&cfg.jobs.maxAttempts, "job-max-attempts", 5, "Attempts before a job is marked as failed")
+	fs.DurationVar(&cfg.jobs.backoff, "job-backoff", 10*time.Second, "Initial retry backoff for failed jobs")
+	fs.DurationVar(&cfg.jobs.maxBackoff, "job-max-backoff", time.Hour, "Maximum retry backoff for failed jobs")
+	fs.DurationVar(&cfg.jobs.retention, "job-retention", 7*24*time.Hour, "Age after which succeeded and cancelled jobs are deleted")
+
+	fs.IntVar(&cfg.outbox.workers, "outbox-workers", 2, "Number of email outbox delivery workers")
+	fs.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", time.Second, "Interval between email outbox polls")
+	fs.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 8, "Delivery attempts before an email is dead-lettered")
+	fs.DurationVar(&cfg.outbox.backoff, "outbox-backoff", 30*time.Second, "Initial retry backoff for failed emails")
+	fs.DurationVar(&cfg.outbox.maxBackoff, "outbox-max-backoff", time.Hour, "Maximum retry backoff for failed emails")
+	fs.DurationVar(&cfg.outbox.retention, "outbox-retention", 7*24*time.Hour, "Age after which sent and dead-lettered emails are deleted")
+
+	fs.DurationVar(&cfg.webhooks.timeout, "webhook-timeout", 10*time.Second, "Timeout for a single webhook delivery attempt")
+	fs.DurationVar(&cfg.webhooks.deliveryRetention, "webhook-delivery-retention", 30*24*time.Hour, "Age after which finished webhook deliveries are deleted")
+	fs.BoolVar(&cfg.webhooks.allowPrivate, "webhook-allow-private", false, "Allow webhooks to loopback, link-local and private addresses, for local testing")
+
+	fs.DurationVar(&cfg.events.retention, "event-retention", 30*24*time.Hour, "Age after which events are deleted")
+
+	fs.BoolVar(&cfg.metrics.enabled, "metrics-enabled", false, "Expose Prometheus metrics at /metrics")
+
+	fs.StringVar(&cfg.trace.exporter, "trace-exporter", "none", "Trace exporter (none|stdout|file|otlp)")
+	fs.StringVar(&cfg.trace.file, "trace-file", "tmp/traces.jsonl", "File the file trace exporter appends spans to")
+	fs.StringVar(&cfg.trace.otlpEndpoint, "trace-otlp-endpoint", "http://localhost:4318", "OTLP/HTTP collector endpoint")
+	fs.Float64Var(&cfg.trace.sampleRatio, "trace-sample-ratio", 1, "Fraction of new traces to sample, between 0 and 1")
+	fs.StringVar(&cfg.trace.serviceName, "trace-service-name", "greenlight", "Service name reported with exported spans")
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "localhost", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "Greenlight <no-reply@greenlight.local>", "SMTP sender")
+}
+
+// layerConfig parses args into fs and returns the positional arguments.
+// Every flag in settings that isn't given in args is then taken from its
+// GREENLIGHT_* environment variable, such as GREENLIGHT_SMTP_PASSWORD for
+// -smtp-password, or else from the config file. The file is named by
+// -config or GREENLIGHT_CONFIG, and its keys are the flag names, optionally
+// split at dashes into tables: smtp.host and [smtp] host both set -smtp-host.
+func layerConfig(fs *flag.FlagSet, settings []string, args []string, getenv func(string) string) ([]string, error) {
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, err
+	}
+
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	if !given["config"] && getenv(envName("config")) != "" {
+		err = fs.Set("config", getenv(envName("config")))
+		if err != nil {
+			return nil, err
+		}
+		given["config"] = true
+	}
+
+	file := configfile.Values{}
+
+	if path := fs.Lookup("config").Value.String(); path != "" {
+		loaded, err := configfile.Load(path)
+		if err != nil {
+			return nil, err
+		}
+
+		known := map[string]string{}
+		for _, name := range settings {
+			known[settingKey(name)] = name
+		}
+
+		keys := make([]string, 0, len(loaded))
+		for key := range loaded {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			name, ok := known[settingKey(key)]
+			if !ok || name == "config" {
+				return nil, fmt.Errorf("%s: unknown setting %q", path, key)
+			}
+
+			if _, exists := file[name]; exists {
+				return nil, fmt.Errorf("%s: setting %q is given twice", path, key)
+			}
+
+			file[name] = loaded[key]
+		}
+	}
+
+	for _, name := range settings {
+		if given[name] {
+			continue
+		}
+
+		if value := getenv(envName(name)); value != "" {
+			err := fs.Set(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s: %w", value, envName(name), err)
+			}
+			continue
+		}
+
+		values, ok := file[name]
+		if !ok {
+			continue
+		}
+
+		if !repeatableSettings[name] {
+			values = []string{strings.Join(values, ",")}
+		}
+
+		for _, value := range values {
+			err := fs.Set(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for %s in %s: %w", value, name, fs.Lookup("config").Value, err)
+			}
+		}
+	}
+
+	return positional, nil
+}
+
+// validateConfig checks every setting, so that mistakes are reported at
+// startup instead of when a setting is first used. The DSN is only needed by
+// commands that use the database.
+func validateConfig(cfg config, needDB bool) error {
+	v := validator.New()
+
+	_, err := jsonlog.ParseLevel(cfg.logLevel)
+	v.Check(err == nil, "log-level", "must be info, error, fatal or off")
+
+	v.Check(cfg.port > 0 && cfg.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(cfg.env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	baseURL, err := url.Parse(cfg.baseURL)
+	v.Check(err == nil && (baseURL.Scheme == "http" || baseURL.Scheme == "https") && baseURL.Host != "", "base-url", "must be an absolute http or https URL")
+	v.Check(cfg.drainDelay >= 0, "drain-delay", "must not be negative")
+
+	v.Check(!needDB || cfg.db.dsn != "", "db_dsn", "must be provided")
+	v.Check(cfg.db.maxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+	v.Check(cfg.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	checkDuration(v, "db-max-idle-time", cfg.db.maxIdleTime)
+	v.Check(cfg.db.queryTimeout >= 0, "db-query-timeout", "must not be negative")
+
+	v.Check(cfg.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+	v.Check(cfg.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+	v.Check(cfg.limiter.authFailureRPS > 0, "limiter-auth-failure-rps", "must be greater than zero")
+	v.Check(cfg.limiter.authFailureBurst > 0, "limiter-auth-failure-burst", "must be greater than zero")
+	v.Check(validator.PermittedValue(cfg.limiter.store, "memory", "postgres"), "limiter-store", "must be memory or postgres")
+
+	v.Check(cfg.login.maxAttempts >= 0, "login-max-attempts", "must not be negative")
+	v.Check(cfg.login.ipMaxAttempts >= 0, "login-ip-max-attempts", "must not be negative")
+	v.Check(cfg.login.window > 0, "login-window", "must be greater than zero")
+	v.Check(cfg.login.lockout >= 0, "login-lockout", "must not be negative")
+	v.Check(cfg.login.delay >= 0, "login-delay", "must not be negative")
+	v.Check(cfg.login.maxDelay >= cfg.login.delay, "login-max-delay", "must not be less than login-delay")
+
+	v.Check(validator.PermittedValue(cfg.token.mode, "opaque", "jwt"), "token-mode", "must be opaque or jwt")
+	v.Check(cfg.token.ttl > 0, "token-ttl", "must be greater than zero")
+	v.Check(validator.PermittedValue(cfg.token.jwt.algorithm, jwt.AlgorithmHS256, jwt.AlgorithmEdDSA), "jwt-alg", "must be HS256 or EdDSA")
+	v.Check(cfg.token.mode != "jwt" || cfg.token.jwt.keys != "", "jwt-keys", "must be provided when token-mode is jwt")
+	v.Check(cfg.token.jwt.denylistRefresh > 0, "jwt-denylist-refresh", "must be greater than zero")
+
+	v.Check(cfg.gdpr.exportTTL > 0, "export-ttl", "must be greater than zero")
+	v.Check(cfg.gdpr.deletionGrace >= 0, "deletion-grace", "must not be negative")
+
+	_, err = cron.Parse(cfg.gdpr.purgeSchedule)
+	v.Check(err == nil, "deletion-purge-schedule", "must be a valid cron schedule")
+
+	v.Check(validator.PermittedValue(cfg.mailer.backend, "smtp", "file", "log", "memory"), "mailer", "must be smtp, file, log or memory")
+	v.Check(cfg.mailer.backend != "file" || cfg.mailer.dir != "", "mailer-dir", "must be provided for the file mailer")
+
+	v.Check(cfg.cleanup.interval >= 0, "cleanup-interval", "must not be negative")
+	v.Check(cfg.cleanup.unactivatedInterval >= 0, "cleanup-unactivated-interval", "must not be negative")
+	v.Check(cfg.cleanup.unactivatedMaxAge > 0, "unactivated-max-age", "must be greater than zero")
+
+	v.Check(cfg.jobs.workers >= 0, "job-workers", "must not be negative")
+	v.Check(cfg.jobs.pollInterval > 0, "job-poll-interval", "must be greater than zero")
+	v.Check(cfg.jobs.maxAttempts > 0, "job-max-attempts", "must be greater than zero")
+	v.Check(cfg.jobs.backoff > 0, "job-backoff", "must be greater than zero")
+	v.Check(cfg.jobs.maxBackoff >= cfg.jobs.backoff, "job-max-backoff", "must not be less than job-backoff")
+	v.Check(cfg.jobs.retention > 0, "job-retention", "must be greater than zero")
+
+	v.Check(cfg.outbox.workers >= 0, "outbox-workers", "must not be negative")
+	v.Check(cfg.outbox.pollInterval > 0, "outbox-poll-interval", "must be greater than zero")
+	v.Check(cfg.outbox.maxAttempts > 0, "outbox-max-attempts", "must be greater than zero")
+	v.Check(cfg.outbox.backoff > 0, "outbox-backoff", "must be greater than zero")
+	v.Check(cfg.outbox.maxBackoff >= cfg.outbox.backoff, "outbox-max-backoff", "must not be less than outbox-backoff")
+	v.Check(cfg.outbox.retention > 0, "outbox-retention", "must be greater than zero")
+
+	v.Check(cfg.webhooks.timeout > 0, "webhook-timeout", "must be greater than zero")
+	v.Check(cfg.webhooks.deliveryRetention > 0, "webhook-delivery-retention", "must be greater than zero")
+
+	v.Check(cfg.events.retention > 0, "event-retention", "must be greater than zero")
+
+	v.Check(validator.PermittedValue(cfg.trace.exporter, "none", "stdout", "file", "otlp"), "trace-exporter", "must be none, stdout, file or otlp")
+	v.Check(cfg.trace.sampleRatio >= 0 && cfg.trace.sampleRatio <= 1, "trace-sample-ratio", "must be between 0 and 1")
+
+	v.Check(cfg.mailer.backend != "smtp" || cfg.smtp.host != "", "smtp-host", "must be provided for the smtp mailer")
+	v.Check(cfg.smtp.port > 0 && cfg.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+	v.Check(cfg.smtp.sender != "", "smtp-sender", "must be provided")
+
+	if !v.Valid() {
+		return fmt.Errorf("invalid configuration: %w", validationError(v))
+	}
+
+	return nil
+}
+
+func checkDuration(v *validator.Validator, key, value string) {
+	d, err := time.ParseDuration(value)
+	v.Check(err == nil && d >= 0, key, "must be a duration such as 15m")
+}
+
+// reloadConfig loads the configuration again from the same command line, the
+// environment and the current contents of the config file.
+func reloadConfig(args []string, getenv func(string) string) (config, error) {
+	var cfg config
+
+	fs := flag.NewFlagSet("greenlight", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	defineConfigFlags(fs, &cfg)
+
+	_, err := layerConfig(fs, flagNames(fs), args, getenv)
+	if err != nil {
+		return config{}, err
+	}
+
+	return cfg, validateConfig(cfg, true)
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process gets SIGHUP
+// and applies the settings that can change while serving: the rate limiter
+// and the log level. Other settings need a restart.
+func (app *application) reloadOnSIGHUP(args []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		cfg, err := reloadConfig(args, os.Getenv)
+		if err != nil {
+			app.logger.PrintError(fmt.Errorf("reloading configuration: %w", err), nil)
+			continue
+		}
+
+		app.applyReloadableConfig(cfg)
+	}
+}
+
+func (app *application) applyReloadableConfig(cfg config) {
+	level, err := jsonlog.ParseLevel(cfg.logLevel)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	limiter := cfg.limiter
+	app.limits.Store(&limiter)
+
+	app.logger.PrintInfo("configuration reloaded", map[string]string{
+		"log_level":        cfg.logLevel,
+		"limiter_enabled":  strconv.FormatBool(limiter.enabled),
+		"limiter_rps":      strconv.FormatFloat(limiter.rps, 'f', -1, 64),
+		"limiter_burst":    strconv.Itoa(limiter.burst),
+		"limiter_policies": strconv.Itoa(len(limiter.policies)),
+	})
+
+	// The new level is set last so that the entry above is always written.
+	app.logger.SetLevel(level)
+}
+
+// limiterSettings returns the rate limiter settings, which SIGHUP may have
+// reloaded since startup.
+func (app *application) limiterSettings() limiterConfig {
+	if limiter := app.limits.Load(); limiter != nil {
+		return *limiter
+	}
+
+	return app.config.limiter
+}
+
+func flagNames(fs *flag.FlagSet) []string {
+	names := []string{}
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+func envName(name string) string {
+	return "GREENLIGHT_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// settingKey normalises flag names and config file keys, so that db_dsn,
+// db-dsn and db.dsn all name the same setting.
+func settingKey(name string) string {
+	return strings.NewReplacer("_", "-", ".", "-").Replace(strings.ToLower(name))
+}
+
+// redactSetting hides the secrets in the value of a setting: the password in
+// the DSN and the values of settings named after passwords or secrets.
+func redactSetting(name, value string) string {
+	switch {
+	case value == "":
+		return value
+	case name == "db_dsn":
+		return redactDSN(value)
+	case strings.Contains(name, "password") || strings.Contains(name, "secret"):
+		return "xxxxx"
+	default:
+		return value
+	}
+}
+
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err == nil && u.Scheme != "" {
+		return u.Redacted()
+	}
+
+	return dsnPasswordRX.ReplaceAllString(dsn, "${1}xxxxx")
+}
@@ -3,14 +3,25 @@ package main
 import (
 	"context"
 	"greenlight.aslan/internal/data"
+	"net"
 	"net/http"
+	"time"
 )
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	apiKeyContextKey      = contextKey("apiKey")
+	requestInfoContextKey = contextKey("requestInfo")
+	connContextKey        = contextKey("conn")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.userID = user.ID
+	}
+
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
@@ -23,3 +34,57 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
+
+// requestInfo is shared between the outermost middleware and the handlers it
+// wraps, so details only known deeper down, like the matched route and the
+// authenticated user, are available when the request is logged.
+type requestInfo struct {
+	id      string
+	route   string
+	userID  int64
+	traceID string
+	spanID  string
+}
+
+func (app *application) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestInfoContextKey, info)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo)
+	if !ok {
+		return &requestInfo{route: "unmatched"}
+	}
+
+	return info
+}
+
+// contextSetConn is the server's ConnContext hook, which keeps the
+// connection each request arrives on around for extendWriteDeadline.
+func (app *application) contextSetConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey, conn)
+}
+
+// extendWriteDeadline moves the write deadline of the connection r arrived
+// on to timeout from now, for responses that outlive the server's write
+// timeout. It does what http.ResponseController does from Go 1.20 on, and
+// nothing for requests served without a connection, like in tests.
+func (app *application) extendWriteDeadline(r *http.Request, timeout time.Duration) error {
+	conn, ok := r.Context().Value(connContextKey).(net.Conn)
+	if !ok {
+		return nil
+	}
+
+	return conn.SetWriteDeadline(time.Now().Add(timeout))
+}
@@ -21,12 +21,13 @@ func (app *application) createDirectorHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	director := &data.Director{
-		Name:    input.Name,
-		Surname: input.Surname,
-		Awards:  input.Awards,
+		Name:      input.Name,
+		Surname:   input.Surname,
+		Awards:    input.Awards,
+		CreatedBy: app.contextGetUser(r).ID,
 	}
 
-	err = app.models.Directors.Insert(director)
+	err = app.models.Directors.Insert(r.Context(), director)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -69,7 +70,7 @@ func (app *application) listDirectorsHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	directors, metadata, err := app.models.Directors.GetAll(input.Name, input.Filters)
+	directors, metadata, err := app.models.Directors.GetAll(r.Context(), input.Name, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
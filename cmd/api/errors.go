@@ -1,20 +1,38 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/trace"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
-	app.logger.PrintError(err, map[string]string{
+	properties := map[string]string{
 		"request_method": r.Method,
 		"request_url":    r.URL.String(),
-	})
+	}
+
+	if id := app.contextGetRequestInfo(r).id; id != "" {
+		properties["request_id"] = id
+	}
+
+	addTraceProperties(properties, trace.SpanFromContext(r.Context()))
+
+	app.logger.PrintError(err, properties)
 }
 
 func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
 	env := envelope{"error": message}
 
+	if id := app.contextGetRequestInfo(r).id; id != "" {
+		env["request_id"] = id
+	}
+
 	err := app.writeJSON(w, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
@@ -22,13 +40,43 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 	}
 }
 
+// statusClientClosedRequest is the non-standard status nginx uses for
+// requests the client gave up on before a response was sent.
+const statusClientClosedRequest = 499
+
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrQueryCanceled):
+		app.clientClosedRequestResponse(w, r)
+		return
+	case errors.Is(err, data.ErrQueryTimeout):
+		app.queryTimeoutResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
 
+// clientClosedRequestResponse is sent when a query was cancelled because the
+// client went away. Nobody is left to read it, but it keeps the cancelled
+// request apart from server errors in the access log and metrics.
+func (app *application) clientClosedRequestResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the request was cancelled by the client"
+	app.errorResponse(w, r, statusClientClosedRequest, message)
+}
+
+func (app *application) queryTimeoutResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+
+	w.Header().Set("Retry-After", "1")
+
+	message := "the server is taking too long to respond, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, message)
+}
+
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
 	app.errorResponse(w, r, http.StatusNotFound, message)
@@ -63,7 +111,8 @@ func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *htt
 }
 
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.Header().Add("WWW-Authenticate", "Bearer")
+	w.Header().Add("WWW-Authenticate", "ApiKey")
 
 	message := "invalid or missing authentication token"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -83,3 +132,20 @@ func (app *application) onlyAdminResponse(w http.ResponseWriter, r *http.Request
 	message := "your account don't have an access to that resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) accountLockedResponse(w http.ResponseWriter, r *http.Request, lockedUntil time.Time) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	message := envelope{
+		"code":         "account_locked",
+		"message":      "your account has been temporarily locked due to too many failed login attempts",
+		"locked_until": lockedUntil,
+	}
+	app.errorResponse(w, r, http.StatusLocked, message)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/validator"
+	"net/http"
+	"time"
+)
+
+const (
+	eventsMaxWait      = 25 * time.Second
+	eventsPollInterval = time.Second
+)
+
+type eventsQuery struct {
+	After int64
+	Limit int
+	Wait  time.Duration
+}
+
+func validateEventsQuery(v *validator.Validator, q eventsQuery) {
+	v.Check(q.After >= 0, "after", "must be zero or more")
+	v.Check(q.Limit > 0, "limit", "must be greater than zero")
+	v.Check(q.Limit <= 1000, "limit", "must be a maximum of 1000")
+	v.Check(q.Wait >= 0, "wait", "must be zero or more")
+	v.Check(q.Wait <= eventsMaxWait, "wait", "must be a maximum of 25")
+}
+
+// listEventsHandler returns the events recorded after the given ID. When
+// there are none yet and wait is set, the request is held open for up to
+// that many seconds until some arrive, so consumers can tail the feed by
+// passing next_after back in as after.
+func (app *application) listEventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input := eventsQuery{
+		After: int64(app.readInt(qs, "after", 0, v)),
+		Limit: app.readInt(qs, "limit", 100, v),
+		Wait:  time.Duration(app.readInt(qs, "wait", 0, v)) * time.Second,
+	}
+
+	if validateEventsQuery(v, input); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deadline := time.Now().Add(input.Wait)
+
+	var (
+		events []*data.Event
+		err    error
+	)
+
+	for {
+		events, err = app.models.Events.GetAfter(r.Context(), input.After, input.Limit)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if len(events) > 0 || !time.Now().Before(deadline) {
+			break
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(eventsPollInterval):
+		}
+	}
+
+	nextAfter := input.After
+	if len(events) > 0 {
+		nextAfter = events[len(events)-1].ID
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"events": events, "next_after": nextAfter}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const deletionTokenTTL = 30 * time.Minute
+
+type exportUserDataPayload struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (app *application) exportUserDataHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	_, err := app.enqueueJob(r.Context(), jobExportUserData, exportUserDataPayload{UserID: user.ID}, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	message := "your data export is being prepared, a download link will be emailed to you shortly"
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) exportUserDataJob(ctx context.Context, payload exportUserDataPayload) error {
+	return app.sendDataExport(ctx, payload.UserID)
+}
+
+func (app *application) sendDataExport(ctx context.Context, userID int64) error {
+	user, err := app.models.Users.Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := app.buildDataExport(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	token, err := app.models.Exports.New(ctx, user.ID, app.config.gdpr.exportTTL, archive)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]any{
+		"name":        user.Name,
+		"downloadURL": fmt.Sprintf("%s/v1/exports/%s", app.config.baseURL, token.Plaintext),
+		"expiry":      token.Expiry.UTC().Format(time.RFC1123),
+	}
+
+	return app.enqueueEmail(ctx, user, "data_export.tmpl", data)
+}
+
+func (app *application) buildDataExport(ctx context.Context, user *data.User) ([]byte, error) {
+	tokens, err := app.models.Tokens.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenMetadata := make([]map[string]any, 0, len(tokens))
+	for _, token := range tokens {
+		tokenMetadata = append(tokenMetadata, map[string]any{
+			"scope":  token.Scope,
+			"expiry": token.Expiry,
+		})
+	}
+
+	apiKeys, err := app.models.APIKeys.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := app.models.Identities.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	movies, err := app.models.Movies.GetAllCreatedBy(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	directors, err := app.models.Directors.GetAllCreatedBy(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	trailers, err := app.models.Trailers.GetAllCreatedBy(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", user},
+		{"tokens.json", tokenMetadata},
+		{"api_keys.json", apiKeys},
+		{"identities.json", identities},
+		{"movies.json", movies},
+		{"directors.json", directors},
+		{"trailers.json", trailers},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+
+	for _, file := range files {
+		js, err := json.MarshalIndent(file.content, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = f.Write(append(js, '\n'))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	archive, err := app.models.Exports.GetArchive(r.Context(), token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="greenlight-export.zip"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(archive)
+}
+
+// deleteUserHandler schedules the deletion of the account once the password
+// is confirmed. Users without a password, who signed up through an identity
+// provider, are emailed a token instead, to confirm the deletion with through
+// confirmUserDeletionHandler.
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user, err := app.models.Users.Get(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !user.Password.IsUsable() {
+		app.requestUserDeletion(w, r, user)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePasswordPlaintext(v, input.Password); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	app.scheduleUserDeletion(w, r, user)
+}
+
+func (app *application) requestUserDeletion(w http.ResponseWriter, r *http.Request, user *data.User) {
+	err := app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeDeletion, user.ID)
+		if err != nil {
+			return err
+		}
+
+		token, err := tx.Tokens.New(r.Context(), user.ID, deletionTokenTTL, data.ScopeDeletion)
+		if err != nil {
+			return err
+		}
+
+		return app.enqueueEmailTx(r.Context(), tx, user, "account_deletion_confirm.tmpl", map[string]any{
+			"name":          user.Name,
+			"deletionToken": token.Plaintext,
+			"expiry":        token.Expiry.UTC().Format(time.RFC1123),
+		})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	message := "a token to confirm the deletion of your account has been emailed to you"
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) confirmUserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(r.Context(), data.ScopeDeletion, input.TokenPlaintext)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A token sent to someone else is as good as an invalid one.
+	if user == nil || user.ID != app.contextGetUser(r).ID {
+		v.AddError("token", "invalid or expired deletion token")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.scheduleUserDeletion(w, r, user)
+}
+
+// scheduleUserDeletion schedules the deletion of the account after the grace
+// period and signs the user out everywhere: their tokens, JWTs and API keys
+// stop working. Signing in again is still possible, to cancel the deletion.
+func (app *application) scheduleUserDeletion(w http.ResponseWriter, r *http.Request, user *data.User) {
+	scheduledFor := time.Now().Add(app.config.gdpr.deletionGrace)
+
+	var revoked map[string]time.Time
+
+	err := app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Users.ScheduleDeletion(r.Context(), user.ID, scheduledFor)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Tokens.DeleteAllForUser(r.Context(), data.ScopeDeletion, user.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.APIKeys.DeleteAllForUser(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		revoked, err = revokeUserTokens(r.Context(), tx, user.ID)
+		if err != nil {
+			return err
+		}
+
+		return app.enqueueEmailTx(r.Context(), tx, user, "account_deletion.tmpl", map[string]any{
+			"name":         user.Name,
+			"scheduledFor": scheduledFor.UTC().Format(time.RFC1123),
+		})
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.denyJWTs(revoked)
+
+	env := envelope{
+		"message":       "your account is scheduled for deletion",
+		"scheduled_for": scheduledFor,
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) cancelUserDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Users.CancelDeletion(r.Context(), app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "the deletion of your account was cancelled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) purgeDeletedUsersJob(ctx context.Context, payload struct{}) error {
+	count, err := app.models.Users.DeleteScheduled(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		app.logger.PrintInfo("deleted user accounts", map[string]string{
+			"count": strconv.FormatInt(count, 10),
+		})
+
+		// The JWTs of the deleted users were revoked along with them.
+		if app.jwtDenylist != nil {
+			return app.refreshJWTDenylist(ctx)
+		}
+	}
+
+	return nil
+}
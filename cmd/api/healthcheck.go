@@ -1,9 +1,91 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"greenlight.aslan/internal/mailer"
+	"greenlight.aslan/internal/migrate"
+	"greenlight.aslan/migrations"
 	"net/http"
+	"sync"
+	"time"
 )
 
+// readinessTimeout bounds the time all readiness checks together may take.
+const readinessTimeout = 2 * time.Second
+
+// smtpCheckInterval is how long the result of the SMTP check is reused, so
+// that frequent probes don't open a connection to the mail server each time.
+const smtpCheckInterval = 30 * time.Second
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// newReadinessChecks returns the dependencies the API can't serve requests
+// without: the database with its schema up to date and, when mail goes out
+// over SMTP, the mail server.
+func newReadinessChecks(db *sql.DB, m mailer.Mailer) ([]readinessCheck, error) {
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []readinessCheck{
+		{"database", db.PingContext},
+		{"migrations", func(ctx context.Context) error {
+			status, err := migrator.Status(ctx)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case status.Dirty:
+				return fmt.Errorf("schema is dirty at version %d", status.Version)
+			case status.Behind():
+				return fmt.Errorf("schema is at version %d of %d", status.Version, status.Latest)
+			}
+
+			return nil
+		}},
+	}
+
+	if smtp, ok := m.(*mailer.SMTPMailer); ok {
+		checks = append(checks, readinessCheck{"smtp", cachedCheck(smtpCheckInterval, smtp.Ping)})
+	}
+
+	return checks, nil
+}
+
+// cachedCheck runs check at most once per interval, returning the previous
+// result in between.
+func cachedCheck(interval time.Duration, check func(ctx context.Context) error) func(ctx context.Context) error {
+	var (
+		mu        sync.Mutex
+		checkedAt time.Time
+		lastErr   error
+	)
+
+	return func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !checkedAt.IsZero() && time.Since(checkedAt) < interval {
+			return lastErr
+		}
+
+		lastErr = check(ctx)
+		checkedAt = time.Now()
+
+		return lastErr
+	}
+}
+
+// healthcheckHandler answers liveness probes. It only tells that the process
+// is up and serving, so that a restart is not triggered by an outage of a
+// dependency.
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 
 	env := envelope{
@@ -19,3 +101,81 @@ func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Reques
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// readinessHandler answers readiness probes, running every readiness check
+// at once and answering 503 when one of them fails or the server is shutting
+// down.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	type result struct {
+		name     string
+		err      error
+		duration time.Duration
+	}
+
+	results := make(chan result, len(app.readiness))
+
+	for _, c := range app.readiness {
+		c := c
+
+		go func() {
+			start := time.Now()
+			err := c.check(ctx)
+			results <- result{c.name, err, time.Since(start)}
+		}()
+	}
+
+	checks := map[string]map[string]string{}
+	ready := true
+
+	for _, c := range app.readiness {
+		checks[c.name] = map[string]string{"status": "fail", "error": "timed out"}
+	}
+
+collect:
+	for range app.readiness {
+		select {
+		case res := <-results:
+			check := map[string]string{"status": "pass", "duration": res.duration.String()}
+			if res.err != nil {
+				check["status"], check["error"] = "fail", res.err.Error()
+			}
+			checks[res.name] = check
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	for _, check := range checks {
+		if check["status"] != "pass" {
+			ready = false
+		}
+	}
+
+	if app.shuttingDown.Load() {
+		checks["shutdown"] = map[string]string{"status": "fail", "error": "server is shutting down"}
+		ready = false
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	env := envelope{
+		"status":           status,
+		"checks":           checks,
+		"background_tasks": app.backgroundTasks.Load(),
+		"system_info": map[string]string{
+			"environment": app.config.env,
+			"version":     version,
+		},
+	}
+
+	err := app.writeJSON(w, code, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"greenlight.aslan/internal/mailer"
 	"greenlight.aslan/internal/validator"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type envelope map[string]any
@@ -130,9 +132,13 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
+	app.backgroundTasks.Add(1)
+	app.metrics.backgroundTasks.Inc()
 
 	go func() {
 		defer app.wg.Done()
+		defer app.backgroundTasks.Add(-1)
+		defer app.metrics.backgroundTasks.Dec()
 
 		defer func() {
 			if err := recover(); err != nil {
@@ -143,3 +149,52 @@ func (app *application) background(fn func()) {
 		fn()
 	}()
 }
+
+func exponentialBackoff(attempt int, base, max time.Duration) time.Duration {
+	backoff := base
+	for i := 1; i < attempt && backoff < max; i++ {
+		backoff *= 2
+	}
+
+	if backoff > max {
+		backoff = max
+	}
+
+	return backoff
+}
+
+func (app *application) readLanguage(r *http.Request) string {
+	return acceptLanguage(r.Header.Get("Accept-Language"), app.templates.Locales())
+}
+
+// acceptLanguage picks the supported language with the highest weight in an
+// Accept-Language header, matching on the primary subtag only.
+func acceptLanguage(header string, supported []string) string {
+	language := mailer.DefaultLocale
+	weight := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if i := strings.IndexAny(tag, "-_"); i != -1 {
+			tag = tag[:i]
+		}
+
+		if q > weight && validator.PermittedValue(tag, supported...) {
+			language = tag
+			weight = q
+		}
+	}
+
+	return language
+}
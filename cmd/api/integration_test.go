@@ -2,15 +2,57 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/jsonlog"
+	"greenlight.aslan/internal/mailer"
+	"greenlight.aslan/internal/oauth"
+	"greenlight.aslan/internal/ratelimit"
+	"greenlight.aslan/internal/validator"
+	"greenlight.aslan/internal/webhook"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+// signInAdmin authenticates req as a new activated admin, who may write to
+// the catalogue.
+func signInAdmin(t *testing.T, app *application, req *http.Request) {
+	t.Helper()
+
+	user := &data.User{
+		Name:      "Admin",
+		Email:     fmt.Sprintf("admin-%d@example.com", time.Now().UnixNano()),
+		Activated: true,
+		Role:      "admin",
+	}
+
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.models.Tokens.New(context.Background(), user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.Plaintext)
+}
+
 // #1
 func TestCreateMovieHandler(t *testing.T) {
 
@@ -21,7 +63,7 @@ func TestCreateMovieHandler(t *testing.T) {
 		t.Errorf("Database is not working correctly: %s", err)
 	}
 
-	app.models.Movies.DB = db
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
 
 	movie := struct {
 		Title   string       `json:"title"`
@@ -45,6 +87,8 @@ func TestCreateMovieHandler(t *testing.T) {
 		t.Errorf("Error in request creation: %s", err)
 	}
 
+	signInAdmin(t, app, req)
+
 	rr := httptest.NewRecorder()
 
 	app.routes().ServeHTTP(rr, req)
@@ -64,7 +108,7 @@ func TestUpdateMovieHandler(t *testing.T) {
 		t.Errorf("Database is not working correctly: %s", err)
 	}
 
-	app.models.Movies.DB = db
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
 
 	m := struct {
 		Title   string       `json:"title"`
@@ -88,6 +132,8 @@ func TestUpdateMovieHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	signInAdmin(t, app, req)
+
 	rr := httptest.NewRecorder()
 	app.routes().ServeHTTP(rr, req)
 
@@ -106,13 +152,15 @@ func TestDeleteMovieHandler(t *testing.T) {
 		t.Errorf("Database is not working correctly: %s", err)
 	}
 
-	app.models.Movies.DB = db
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
 
 	req, err := http.NewRequest("DELETE", "/v1/movies/1", nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	signInAdmin(t, app, req)
+
 	rr := httptest.NewRecorder()
 	app.routes().ServeHTTP(rr, req)
 
@@ -131,7 +179,7 @@ func TestShowMovieHandler(t *testing.T) {
 		t.Errorf("Database is not working correctly: %s", err)
 	}
 
-	app.models.Movies.DB = db
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
 
 	type Movie struct {
 		Title   string       `json:"title"`
@@ -204,8 +252,8 @@ func TestCreateAuthenticationTokenHandle(t *testing.T) {
 		t.Errorf("Database is not working correctly: %s", err)
 	}
 
-	app.models.Tokens.DB = db
-	app.models.Users.DB = db
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
+	app.config.token.ttl = 24 * time.Hour
 
 	input := struct {
 		Email    string `json:"email"`
@@ -270,19 +318,25 @@ func TestRegisterUserHandler(t *testing.T) {
 		t.Errorf("Database is not working correctly: %s", err)
 	}
 
-	app.models.Users.DB = db
-	app.models.Tokens.DB = db
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
+
+	app.logger = jsonlog.New(io.Discard, jsonlog.LevelInfo)
+	app.templates, err = mailer.LoadTemplates("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inbox := mailer.NewMemory(app.templates, "Greenlight <no-reply@greenlight.local>")
+	app.mailer = inbox
 
 	input := struct {
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
-		Role     string `json:"role"`
 	}{
 		"Kusainov Aslan",
 		"nissanfordo03@gmail.com",
 		"12345678",
-		"User",
 	}
 
 	userObj, err := json.Marshal(input)
@@ -312,7 +366,7 @@ func TestRegisterUserHandler(t *testing.T) {
 	}{
 		expectedId:        1,
 		expectedActivated: false,
-		expectedRole:      "User",
+		expectedRole:      "user",
 	}
 
 	err = json.Unmarshal([]byte(body), &userInput)
@@ -333,6 +387,20 @@ func TestRegisterUserHandler(t *testing.T) {
 	if status := rr.Code; status != http.StatusCreated {
 		t.Errorf("User registration handler returned wrong status code: got - \"%v\", expected - \"%v\"", status, http.StatusOK)
 	}
+
+	app.deliverOutbox(context.Background())
+
+	welcome := inbox.Last(input.Email, "user_welcome.tmpl")
+	if welcome == nil {
+		t.Fatalf("Welcome email was not sent to %s", input.Email)
+	}
+
+	activationToken, _ := welcome.Value("activationToken").(string)
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, activationToken); !v.Valid() {
+		t.Errorf("Welcome email has an invalid activation token: %q", activationToken)
+	}
 }
 
 // #7
@@ -345,7 +413,7 @@ func TestListMoviesHandler(t *testing.T) {
 		t.Errorf("Database is not working correctly: %s", err)
 	}
 
-	app.models.Movies.DB = db
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
 
 	md := struct {
 		CurrentPage  int `json:"current_page"`
@@ -391,3 +459,311 @@ func TestListMoviesHandler(t *testing.T) {
 		t.Errorf("The number of users in METADATA is not equal to DB: got - %d, expected - %d", md.TotalRecords, expectedTotalRecords)
 	}
 }
+
+type fakeOIDCIssuer struct {
+	server        *httptest.Server
+	clientID      string
+	subject       string
+	email         string
+	code          string
+	codeChallenge string
+	nonce         string
+}
+
+func newFakeOIDCIssuer(t *testing.T) *fakeOIDCIssuer {
+	issuer := &fakeOIDCIssuer{
+		clientID: "greenlight-test",
+		subject:  "fake-subject-1",
+		email:    "oidc-user@example.com",
+		code:     "fake-authorization-code",
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"userinfo_endpoint":      issuer.server.URL + "/userinfo",
+		})
+	})
+
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+
+		if qs.Get("code_challenge_method") != "S256" || qs.Get("client_id") != issuer.clientID {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+
+		issuer.codeChallenge = qs.Get("code_challenge")
+		issuer.nonce = qs.Get("nonce")
+
+		redirect := qs.Get("redirect_uri") + "?code=" + issuer.code + "&state=" + qs.Get("state")
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+
+		if r.PostForm.Get("code") != issuer.code || oauth.CodeChallenge(r.PostForm.Get("code_verifier")) != issuer.codeChallenge {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+
+		claims, _ := json.Marshal(map[string]any{
+			"iss":            issuer.server.URL,
+			"sub":            issuer.subject,
+			"aud":            issuer.clientID,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          issuer.nonce,
+			"email":          issuer.email,
+			"email_verified": true,
+		})
+
+		idToken := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString(claims) + ".c2ln"
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "fake-access-token",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// #8
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	issuer := newFakeOIDCIssuer(t)
+
+	provider, err := oauth.NewProvider(context.Background(), oauth.Config{
+		Name:        "fake",
+		Issuer:      issuer.server.URL,
+		ClientID:    issuer.clientID,
+		RedirectURL: "http://localhost:4000/v1/oauth/fake/callback",
+	}, issuer.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := oauth.RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := issuer.server.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(provider.AuthCodeURL("some-state", "some-nonce", verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	location, err := res.Location()
+	if err != nil {
+		t.Fatalf("Authorization endpoint did not redirect back: status %d", res.StatusCode)
+	}
+
+	if location.Query().Get("state") != "some-state" {
+		t.Errorf("OAuth state: got \"%s\" - expected \"%s\"", location.Query().Get("state"), "some-state")
+	}
+
+	_, err = provider.Exchange(context.Background(), location.Query().Get("code"), "wrong-verifier", "some-nonce")
+	if err == nil {
+		t.Errorf("Exchange succeeded with a wrong PKCE code verifier")
+	}
+
+	_, err = provider.Exchange(context.Background(), location.Query().Get("code"), verifier, "other-nonce")
+	if !errors.Is(err, oauth.ErrInvalidIDToken) {
+		t.Errorf("Exchange with a wrong nonce: got \"%v\" - expected \"%v\"", err, oauth.ErrInvalidIDToken)
+	}
+
+	identity, err := provider.Exchange(context.Background(), location.Query().Get("code"), verifier, "some-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Subject != issuer.subject {
+		t.Errorf("Identity subject: got \"%s\" - expected \"%s\"", identity.Subject, issuer.subject)
+	}
+	if identity.Email != issuer.email || !identity.EmailVerified {
+		t.Errorf("Identity email: got \"%s\" (verified %v) - expected \"%s\" (verified true)", identity.Email, identity.EmailVerified, issuer.email)
+	}
+}
+
+// #9
+func TestMovieWebhookDelivery(t *testing.T) {
+	app := &application{}
+
+	db, err := dbConnection()
+	if err != nil {
+		t.Errorf("Database is not working correctly: %s", err)
+	}
+
+	app.models = data.NewModels(db, data.DefaultQueryTimeout)
+	app.logger = jsonlog.New(io.Discard, jsonlog.LevelInfo)
+	app.config.jobs.maxAttempts = 3
+	app.config.webhooks.timeout = 5 * time.Second
+	app.config.webhooks.allowPrivate = true
+
+	secret := "a-very-secret-webhook-key"
+	received := make(chan error, 1)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- webhook.Verify(secret, r.Header.Get(webhook.SignatureHeader), body, 5*time.Minute, time.Now())
+	}))
+	defer receiver.Close()
+
+	hook := &data.Webhook{
+		URL:    receiver.URL,
+		Events: []string{data.EventMovieCreated},
+		Secret: secret,
+		Active: true,
+	}
+
+	err = app.models.Webhooks.Insert(context.Background(), hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.models.Webhooks.Delete(context.Background(), hook.ID)
+
+	movie := `{"title": "Paddington", "year": 2014, "runtime": "95 mins", "genres": ["comedy"]}`
+
+	req, err := http.NewRequest("POST", "/v1/movies", strings.NewReader(movie))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signInAdmin(t, app, req)
+
+	rr := httptest.NewRecorder()
+
+	app.routes().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("CreateMovie handler returned wrong status code: got - \"%v\", expected - \"%v\"", rr.Code, http.StatusCreated)
+	}
+
+	app.runJobs(context.Background(), context.Background(), app.jobHandlers())
+
+	select {
+	case err := <-received:
+		if err != nil {
+			t.Errorf("Webhook signature did not verify: %v", err)
+		}
+	default:
+		t.Fatal("Webhook receiver was not called")
+	}
+
+	deliveries, _, err := app.models.Webhooks.GetAllDeliveries(context.Background(), hook.ID, data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafelist: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Status != data.DeliveryStatusSucceeded {
+		t.Errorf("Webhook delivery log: got %+v", deliveries)
+	}
+}
+
+// #10
+func TestPostgresRepositories(t *testing.T) {
+	db, err := dbConnection()
+	if err != nil {
+		t.Fatalf("Database is not working correctly: %s", err)
+	}
+
+	testRepositoryContract(t, data.NewModels(db, data.DefaultQueryTimeout))
+}
+
+// #11
+func TestReadinessWithDatabase(t *testing.T) {
+	db, err := dbConnection()
+	if err != nil {
+		t.Fatalf("Database is not working correctly: %s", err)
+	}
+
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelOff)}
+
+	app.readiness, err = newReadinessChecks(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	app.readinessHandler(rr, httptest.NewRequest(http.MethodGet, "/v1/healthcheck/ready", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Readiness against a migrated database: got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+// #12
+func TestPostgresRateLimiter(t *testing.T) {
+	db, err := dbConnection()
+	if err != nil {
+		t.Fatalf("Database is not working correctly: %s", err)
+	}
+
+	limiter := ratelimit.NewPostgres(db)
+	key := fmt.Sprintf("test:%d", time.Now().UnixNano())
+	limit := ratelimit.Limit{Rate: 0.001, Burst: 5}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		allowed int
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			result, err := limiter.Allow(context.Background(), key, limit)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if result.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if allowed != limit.Burst {
+		t.Errorf("Concurrent requests: got %d allowed - expected %d", allowed, limit.Burst)
+	}
+
+	result, err := limiter.Allow(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter <= 0 {
+		t.Errorf("Exhausted bucket: got %+v", result)
+	}
+
+	_, err = limiter.DeleteIdle(context.Background(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.aslan/internal/cron"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/trace"
+	"greenlight.aslan/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const jobLease = 10 * time.Minute
+
+const (
+	jobExportUserData          = "export_user_data"
+	jobPurgeDeletedUsers       = "purge_deleted_users"
+	jobCleanupExpiredData      = "cleanup_expired_data"
+	jobCleanupUnactivatedUsers = "cleanup_unactivated_users"
+	jobDeliverWebhook          = "deliver_webhook"
+)
+
+type jobHandler func(ctx context.Context, payload json.RawMessage) error
+
+// typedJob adapts a handler taking a concrete payload type to a jobHandler,
+// decoding the stored JSON payload before each run.
+func typedJob[T any](fn func(ctx context.Context, payload T) error) jobHandler {
+	return func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+
+		err := json.Unmarshal(raw, &payload)
+		if err != nil {
+			return fmt.Errorf("decoding job payload: %w", err)
+		}
+
+		return fn(ctx, payload)
+	}
+}
+
+type recurringJob struct {
+	kind     string
+	schedule cron.Schedule
+}
+
+func (app *application) jobHandlers() map[string]jobHandler {
+	return map[string]jobHandler{
+		jobExportUserData:          typedJob(app.exportUserDataJob),
+		jobPurgeDeletedUsers:       typedJob(app.purgeDeletedUsersJob),
+		jobCleanupExpiredData:      typedJob(app.cleanupExpiredDataJob),
+		jobCleanupUnactivatedUsers: typedJob(app.cleanupUnactivatedUsersJob),
+		jobDeliverWebhook:          typedJob(app.deliverWebhookJob),
+	}
+}
+
+// recurringJobs returns the jobs queued on a schedule. Interval based jobs
+// with a zero interval are disabled.
+func (app *application) recurringJobs() ([]recurringJob, error) {
+	schedule, err := cron.Parse(app.config.gdpr.purgeSchedule)
+	if err != nil {
+		return nil, fmt.Errorf("schedule for %s: %w", jobPurgeDeletedUsers, err)
+	}
+
+	jobs := []recurringJob{
+		{kind: jobPurgeDeletedUsers, schedule: schedule},
+	}
+
+	intervals := []struct {
+		kind     string
+		interval time.Duration
+	}{
+		{jobCleanupExpiredData, app.config.cleanup.interval},
+		{jobCleanupUnactivatedUsers, app.config.cleanup.unactivatedInterval},
+	}
+
+	for _, job := range intervals {
+		if job.interval > 0 {
+			jobs = append(jobs, recurringJob{kind: job.kind, schedule: cron.Every(job.interval)})
+		}
+	}
+
+	return jobs, nil
+}
+
+func (app *application) enqueueJob(ctx context.Context, kind string, payload any, runAt time.Time) (*data.Job, error) {
+	return app.enqueueJobTx(ctx, app.models, kind, payload, runAt)
+}
+
+func (app *application) enqueueJobTx(ctx context.Context, models data.Models, kind string, payload any, runAt time.Time) (*data.Job, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &data.Job{
+		Kind:        kind,
+		Payload:     js,
+		MaxAttempts: app.config.jobs.maxAttempts,
+		RunAt:       runAt,
+	}
+
+	err = models.Jobs.Insert(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// startJobs starts the job workers and the scheduler, which stop claiming
+// work when ctx is done. Jobs already running are handed jobCtx instead, so
+// that they can finish while the server drains.
+func (app *application) startJobs(ctx, jobCtx context.Context) error {
+	recurring, err := app.recurringJobs()
+	if err != nil {
+		return err
+	}
+
+	handlers := app.jobHandlers()
+
+	for i := 0; i < app.config.jobs.workers; i++ {
+		app.background(func() {
+			ticker := time.NewTicker(app.config.jobs.pollInterval)
+			defer ticker.Stop()
+
+			for {
+				app.runJobs(ctx, jobCtx, handlers)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		})
+	}
+
+	app.background(func() {
+		app.scheduleJobs(ctx, recurring)
+	})
+
+	return nil
+}
+
+// scheduleJobs queues each recurring job whenever its schedule comes due. The
+// due time is part of the job's unique key, so when several instances run
+// the scheduler only one of them gets to queue each run.
+func (app *application) scheduleJobs(ctx context.Context, recurring []recurringJob) {
+	next := make([]time.Time, len(recurring))
+	for i, job := range recurring {
+		next[i] = app.nextRun(job, time.Now())
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for i, job := range recurring {
+				if next[i].IsZero() || now.Before(next[i]) {
+					continue
+				}
+
+				err := app.models.Jobs.Insert(ctx, &data.Job{
+					Kind:        job.kind,
+					MaxAttempts: app.config.jobs.maxAttempts,
+					UniqueKey:   fmt.Sprintf("%s@%d", job.kind, next[i].Unix()),
+					RunAt:       next[i],
+				})
+				if err != nil {
+					app.logger.PrintError(err, map[string]string{"job_kind": job.kind})
+					continue
+				}
+
+				next[i] = app.nextRun(job, now)
+			}
+		}
+	}
+}
+
+// nextRun returns when the job is next due after t. A schedule that never
+// comes due again returns the zero time, which stops the job from being
+// queued.
+func (app *application) nextRun(job recurringJob, t time.Time) time.Time {
+	next := job.schedule.Next(t)
+	if next.IsZero() {
+		app.logger.PrintError(fmt.Errorf("%w: no run due after %s", cron.ErrInvalidSpec, t.UTC().Format(time.RFC3339)), map[string]string{
+			"job_kind": job.kind,
+		})
+	}
+
+	return next
+}
+
+func (app *application) runJobs(ctx, jobCtx context.Context, handlers map[string]jobHandler) {
+	for ctx.Err() == nil {
+		jobs, err := app.models.Jobs.Claim(ctx, 1, jobLease)
+		if err != nil {
+			if !errors.Is(err, data.ErrQueryCanceled) {
+				app.logger.PrintError(err, nil)
+			}
+			return
+		}
+
+		if len(jobs) == 0 {
+			return
+		}
+
+		for _, job := range jobs {
+			app.runJob(jobCtx, job, handlers)
+		}
+	}
+}
+
+// runJob runs a claimed job in a trace of its own. The handler is cancelled
+// along with jobCtx, but the bookkeeping after it finishes isn't, so that a
+// job completing or cut short during shutdown is still recorded.
+func (app *application) runJob(jobCtx context.Context, job *data.Job, handlers map[string]jobHandler) {
+	ctx, span := app.tracer.Start(context.Background(), "job "+job.Kind, trace.KindInternal,
+		trace.Int("job.id", job.ID),
+		trace.String("job.kind", job.Kind),
+		trace.Int("job.attempts", int64(job.Attempts)),
+	)
+	defer span.End()
+
+	properties := map[string]string{
+		"job_id":   strconv.FormatInt(job.ID, 10),
+		"job_kind": job.Kind,
+		"attempts": strconv.Itoa(job.Attempts),
+	}
+
+	addTraceProperties(properties, span)
+
+	handler, ok := handlers[job.Kind]
+	if !ok {
+		err := fmt.Errorf("no handler for job kind %q", job.Kind)
+		span.RecordError(err)
+		app.logger.PrintError(err, properties)
+
+		err = app.models.Jobs.Fail(ctx, job.ID, err, time.Now(), true)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+		return
+	}
+
+	// Jobs get until the end of their lease to finish, after which another
+	// worker may pick them up again.
+	handlerCtx, cancel := context.WithTimeout(trace.ContextWithSpan(jobCtx, span), jobLease)
+	defer cancel()
+
+	err := callJobHandler(handlerCtx, handler, job.Payload)
+	if err == nil {
+		err = app.models.Jobs.Complete(ctx, job.ID)
+		if err != nil {
+			app.logger.PrintError(err, properties)
+		}
+		return
+	}
+
+	final := job.Attempts >= job.MaxAttempts
+	runAt := time.Now().Add(exponentialBackoff(job.Attempts, app.config.jobs.backoff, app.config.jobs.maxBackoff))
+
+	if final {
+		properties["status"] = data.JobStatusFailed
+	}
+
+	span.RecordError(err)
+	app.logger.PrintError(err, properties)
+
+	err = app.models.Jobs.Fail(ctx, job.ID, err, runAt, final)
+	if err != nil {
+		app.logger.PrintError(err, properties)
+	}
+}
+
+func callJobHandler(ctx context.Context, handler jobHandler, payload json.RawMessage) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%s", rec)
+		}
+	}()
+
+	return handler(ctx, payload)
+}
+
+func (app *application) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		Kind   string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+	input.Kind = app.readString(qs, "kind", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "run_at", "attempts",
+		"-id", "-created_at", "-run_at", "-attempts"}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.JobStatusPending, data.JobStatusRunning, data.JobStatusSucceeded, data.JobStatusFailed, data.JobStatusCancelled), "status", "invalid status value")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.models.Jobs.GetAll(r.Context(), input.Status, input.Kind, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"jobs": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.readJob(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) retryJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.readJob(w, r)
+	if !ok {
+		return
+	}
+
+	app.transitionJob(w, r, app.models.Jobs.Retry(r.Context(), job.ID), "job queued for another run", "only failed or cancelled jobs can be retried")
+}
+
+func (app *application) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.readJob(w, r)
+	if !ok {
+		return
+	}
+
+	app.transitionJob(w, r, app.models.Jobs.Cancel(r.Context(), job.ID), "job cancelled", "only pending jobs can be cancelled")
+}
+
+func (app *application) readJob(w http.ResponseWriter, r *http.Request) (*data.Job, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	job, err := app.models.Jobs.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return job, true
+}
+
+func (app *application) transitionJob(w http.ResponseWriter, r *http.Request, err error, message, conflict string) {
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, conflict)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/jwt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func loadJWTKeys(cfg config) (*jwt.KeySet, error) {
+	if cfg.token.jwt.keys == "" {
+		return nil, errors.New("jwt-keys must be provided when token-mode is jwt")
+	}
+
+	var keys []*jwt.Key
+
+	for _, spec := range strings.Split(cfg.token.jwt.keys, ",") {
+		kid, path, found := strings.Cut(strings.TrimSpace(spec), ":")
+		if !found || kid == "" || path == "" {
+			return nil, fmt.Errorf("invalid jwt key %q, expected kid:path", spec)
+		}
+
+		key, err := jwt.LoadKey(cfg.token.jwt.algorithm, kid, path)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return jwt.NewKeySet(keys...)
+}
+
+func (app *application) refreshJWTDenylist(ctx context.Context) error {
+	revoked, err := app.models.RevokedTokens.GetAllActive(ctx)
+	if err != nil {
+		return err
+	}
+
+	app.jwtDenylist.Replace(revoked)
+
+	return nil
+}
+
+func (app *application) newJWT(ctx context.Context, user *data.User) (*data.Token, error) {
+	id, err := jwt.NewID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	claims := jwt.Claims{
+		ID:        id,
+		Issuer:    app.config.token.jwt.issuer,
+		Subject:   strconv.FormatInt(user.ID, 10),
+		Activated: user.Activated,
+		Role:      user.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(app.config.token.ttl).Unix(),
+	}
+
+	signed, err := app.jwtKeys.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.RevokedTokens.InsertIssued(ctx, claims.ID, user.ID, claims.Expiry())
+	if err != nil {
+		return nil, err
+	}
+
+	token := &data.Token{
+		Plaintext: signed,
+		UserID:    user.ID,
+		Expiry:    claims.Expiry(),
+		Scope:     data.ScopeAuthentication,
+	}
+
+	return token, nil
+}
+
+func (app *application) verifyJWT(token string) (*jwt.Claims, error) {
+	claims, err := app.jwtKeys.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != app.config.token.jwt.issuer || app.jwtDenylist.Contains(claims.ID) {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// userFromJWT builds the user a token was issued to from its claims, without
+// going to the database. The tokens of a user are revoked when their
+// activation changes or their account is deleted, so the claims are never
+// staler than the denylist. Handlers that need the full record load it.
+func userFromJWT(claims *jwt.Claims) (*data.User, error) {
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil || id < 1 {
+		return nil, jwt.ErrInvalidToken
+	}
+
+	user := &data.User{
+		ID:        id,
+		Activated: claims.Activated,
+		Role:      claims.Role,
+	}
+
+	return user, nil
+}
+
+// revokeUserTokens signs a user out everywhere, deleting their authentication
+// tokens and revoking the JWTs issued to them. The revoked JWTs are returned
+// for denyJWTs, to be called once tx has committed.
+func revokeUserTokens(ctx context.Context, tx data.Models, userID int64) (map[string]time.Time, error) {
+	err := tx.Tokens.DeleteAllForUser(ctx, data.ScopeAuthentication, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return tx.RevokedTokens.RevokeAllForUser(ctx, userID)
+}
+
+// denyJWTs rejects revoked JWTs on this instance straight away. Other
+// instances reject them from their next denylist refresh on.
+func (app *application) denyJWTs(revoked map[string]time.Time) {
+	if app.jwtDenylist == nil {
+		return
+	}
+
+	for id, expiry := range revoked {
+		app.jwtDenylist.Add(id, expiry)
+	}
+}
+
+// startJWTDenylistRefresh reloads the revoked tokens periodically, so that
+// tokens revoked through other instances are rejected here too.
+func (app *application) startJWTDenylistRefresh(ctx context.Context) {
+	app.background(func() {
+		ticker := time.NewTicker(app.config.token.jwt.denylistRefresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := app.refreshJWTDenylist(ctx)
+				if err != nil && !errors.Is(err, data.ErrQueryCanceled) {
+					app.logger.PrintError(err, nil)
+				}
+			}
+		}
+	})
+}
+
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -4,31 +4,111 @@ import (
 	"context"
 	"database/sql"
 	"flag"
-	_ "github.com/lib/pq"
+	"fmt"
+	"github.com/lib/pq"
 	"greenlight.aslan/internal/data"
 	"greenlight.aslan/internal/jsonlog"
+	"greenlight.aslan/internal/jwt"
 	"greenlight.aslan/internal/mailer"
+	"greenlight.aslan/internal/oauth"
+	"greenlight.aslan/internal/ratelimit"
+	"greenlight.aslan/internal/trace"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	file       string
+	logLevel   string
+	port       int
+	env        string
+	baseURL    string
+	drainDelay time.Duration
+	db         struct {
 		dsn          string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  string
+		queryTimeout time.Duration
+		autoMigrate  bool
 	}
-	limiter struct {
-		rps     float64
-		burst   int
+	limiter        limiterConfig
+	trustedProxies prefixList
+	login          struct {
+		maxAttempts   int
+		ipMaxAttempts int
+		window        time.Duration
+		lockout       time.Duration
+		delay         time.Duration
+		maxDelay      time.Duration
+	}
+	token struct {
+		mode string
+		ttl  time.Duration
+		jwt  struct {
+			algorithm       string
+			keys            string
+			issuer          string
+			denylistRefresh time.Duration
+		}
+	}
+	oauth struct {
+		configFile string
+		providers  []oauth.Config
+	}
+	gdpr struct {
+		exportTTL     time.Duration
+		deletionGrace time.Duration
+		purgeSchedule string
+	}
+	mailer struct {
+		backend   string
+		dir       string
+		templates string
+	}
+	cleanup struct {
+		interval            time.Duration
+		unactivatedInterval time.Duration
+		unactivatedMaxAge   time.Duration
+	}
+	jobs struct {
+		workers      int
+		pollInterval time.Duration
+		maxAttempts  int
+		backoff      time.Duration
+		maxBackoff   time.Duration
+		retention    time.Duration
+	}
+	outbox struct {
+		workers      int
+		pollInterval time.Duration
+		maxAttempts  int
+		backoff      time.Duration
+		maxBackoff   time.Duration
+		retention    time.Duration
+	}
+	webhooks struct {
+		timeout           time.Duration
+		deliveryRetention time.Duration
+		allowPrivate      bool
+	}
+	events struct {
+		retention time.Duration
+	}
+	metrics struct {
 		enabled bool
 	}
+	trace struct {
+		exporter     string
+		file         string
+		otlpEndpoint string
+		sampleRatio  float64
+		serviceName  string
+	}
 	smtp struct {
 		host     string
 		port     int
@@ -38,68 +118,196 @@ type config struct {
 	}
 }
 
+type limiterConfig struct {
+	rps              float64
+	burst            int
+	authFailureRPS   float64
+	authFailureBurst int
+	enabled          bool
+	store            string
+	exemptRoles      string
+	policies         []rateLimitPolicy
+}
+
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config          config
+	logger          *jsonlog.Logger
+	models          data.Models
+	mailer          mailer.Mailer
+	templates       *mailer.Templates
+	jwtKeys         *jwt.KeySet
+	jwtDenylist     *jwt.Denylist
+	oauthProviders  map[string]*oauth.Provider
+	movieEvents     *eventBroker
+	metrics         appMetrics
+	tracer          *trace.Tracer
+	limiter         ratelimit.Limiter
+	limits          atomic.Pointer[limiterConfig]
+	readiness       []readinessCheck
+	shuttingDown    atomic.Bool
+	backgroundTasks atomic.Int64
+	wg              sync.WaitGroup
 }
 
 func main() {
 	var cfg config
 
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	cmd, args, err := findCommand(commands(), os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n\n%s", err, usage(commands()))
+		os.Exit(2)
+	}
 
-	flag.StringVar(&cfg.db.dsn, "db_dsn", os.Getenv("GREENLIGHT_DB_DSN"), "PostgresSQL DSN")
+	defineConfigFlags(flag.CommandLine, &cfg)
+	settings := flagNames(flag.CommandLine)
 
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	if cmd.flags != nil {
+		cmd.flags(flag.CommandLine)
+	}
 
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	// Commands other than serve print their results to stdout, so their logs
+	// go to stderr.
+	logOutput := os.Stderr
+	if cmd.name == "serve" {
+		logOutput = os.Stdout
+	}
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "smtp-mail.outlook.com", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 587, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "211422@astanait.edu.kz", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "FirstStagetogrow21", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "Aslan_Kusainov <211422@astanait.edu.kz>", "SMTP sender")
+	logger := jsonlog.New(logOutput, jsonlog.LevelInfo)
 
-	flag.Parse()
+	configArgs := args
 
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	args, err = layerConfig(flag.CommandLine, settings, args, os.Getenv)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
 
-	db, err := openDB(cfg)
+	err = validateConfig(cfg, cmd.db)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 
-	defer db.Close()
+	level, _ := jsonlog.ParseLevel(cfg.logLevel)
+	logger.SetLevel(level)
+
+	c := &cli{
+		app:    &application{config: cfg, logger: logger},
+		args:   configArgs,
+		flags:  flag.CommandLine,
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+	}
+
+	if cmd.db {
+		c.app.tracer, err = newTracer(cfg, logger)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
 
-	logger.PrintInfo("database connection pool established", nil)
+		c.db, err = openDB(cfg, c.app.tracer)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
 
-	app := &application{
-		config: cfg,
-		logger: nil,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		defer c.db.Close()
+
+		logger.PrintInfo("database connection pool established", nil)
+
+		c.app.models = data.NewModels(c.db, cfg.db.queryTimeout)
 	}
 
-	err = app.serve()
+	err = cmd.run(c, args)
 	if err != nil {
 		logger.PrintFatal(err, nil)
 	}
 }
 
-func openDB(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// runServe checks the schema, sets up everything the API needs on top of the
+// database and serves it until the process is told to shut down.
+func runServe(c *cli, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve takes no arguments, got %q", args)
+	}
+
+	app, cfg, logger := c.app, c.app.config, c.app.logger
+
+	err := checkSchema(c.db, logger, cfg.db.autoMigrate)
+	if err != nil {
+		return err
+	}
+
+	app.movieEvents = newEventBroker()
+
+	go app.reloadOnSIGHUP(c.args)
+
+	if cfg.metrics.enabled {
+		app.metrics = newAppMetrics(c.db)
+	}
+
+	app.templates, err = mailer.LoadTemplates(cfg.mailer.templates)
+	if err != nil {
+		return err
+	}
+
+	app.mailer, err = newMailer(cfg, logger, app.templates)
+	if err != nil {
+		return err
+	}
+
+	app.limiter = ratelimit.NewMemory()
+	if cfg.limiter.store == "postgres" {
+		app.limiter = ratelimit.NewPostgres(c.db)
+	}
+
+	app.readiness, err = newReadinessChecks(c.db, app.mailer)
+	if err != nil {
+		return err
+	}
+
+	app.oauthProviders, err = loadOAuthProviders(cfg, app.tracer)
+	if err != nil {
+		return err
+	}
+
+	if cfg.token.mode == "jwt" {
+		app.jwtKeys, err = loadJWTKeys(cfg)
+		if err != nil {
+			return err
+		}
+
+		app.jwtDenylist = jwt.NewDenylist()
+
+		err = app.refreshJWTDenylist(context.Background())
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.serve()
+}
+
+func newMailer(cfg config, logger *jsonlog.Logger, templates *mailer.Templates) (mailer.Mailer, error) {
+	switch cfg.mailer.backend {
+	case "smtp":
+		return mailer.NewSMTP(templates, cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender), nil
+	case "file":
+		return mailer.NewFile(templates, cfg.mailer.dir, cfg.smtp.sender)
+	case "log":
+		return mailer.NewLog(templates, logger, cfg.smtp.sender), nil
+	case "memory":
+		return mailer.NewMemory(templates, cfg.smtp.sender), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer backend %q", cfg.mailer.backend)
+	}
+}
+
+func openDB(cfg config, tracer *trace.Tracer) (*sql.DB, error) {
+	connector, err := pq.NewConnector(cfg.db.dsn)
 	if err != nil {
 		return nil, err
 	}
 
+	db := sql.OpenDB(trace.WrapConnector(connector, tracer))
+
 	db.SetMaxOpenConns(cfg.db.maxOpenConns)
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
 
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.aslan/internal/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// appMetrics holds the metrics recorded across the application. Every field
+// is nil until newAppMetrics is called, and recording to a nil metric does
+// nothing, so handlers and workers don't have to check whether metrics are
+// enabled.
+type appMetrics struct {
+	registry          *metrics.Registry
+	requests          *metrics.Counter
+	requestDuration   *metrics.Histogram
+	requestsInFlight  *metrics.Gauge
+	rateLimitRejected *metrics.Counter
+	backgroundTasks   *metrics.Gauge
+	emailsSent        *metrics.Counter
+}
+
+func newAppMetrics(db *sql.DB) appMetrics {
+	registry := metrics.NewRegistry()
+
+	m := appMetrics{
+		registry:          registry,
+		requests:          registry.NewCounter("greenlight_http_requests_total", "HTTP requests handled.", "method", "route", "status"),
+		requestDuration:   registry.NewHistogram("greenlight_http_request_duration_seconds", "HTTP request latency.", metrics.DefBuckets, "method", "route", "status"),
+		requestsInFlight:  registry.NewGauge("greenlight_http_requests_in_flight", "HTTP requests currently being handled."),
+		rateLimitRejected: registry.NewCounter("greenlight_rate_limit_rejections_total", "Requests rejected by the rate limiter."),
+		backgroundTasks:   registry.NewGauge("greenlight_background_tasks", "Background goroutines currently running."),
+		emailsSent:        registry.NewCounter("greenlight_mailer_sends_total", "Emails handed to the mailer, by result.", "result"),
+	}
+
+	dbStats := []struct {
+		name  string
+		help  string
+		kind  string
+		value func(sql.DBStats) float64
+	}{
+		{"greenlight_db_max_open_connections", "Maximum number of open database connections.", "gauge", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"greenlight_db_open_connections", "Open database connections.", "gauge", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"greenlight_db_in_use_connections", "Database connections currently in use.", "gauge", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"greenlight_db_idle_connections", "Idle database connections.", "gauge", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"greenlight_db_wait_count_total", "Times a query waited for a database connection.", "counter", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"greenlight_db_wait_duration_seconds_total", "Time spent waiting for database connections.", "counter", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"greenlight_db_max_idle_closed_total", "Connections closed because of the idle connection limit.", "counter", func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"greenlight_db_max_idle_time_closed_total", "Connections closed because of the idle time limit.", "counter", func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"greenlight_db_max_lifetime_closed_total", "Connections closed because of the lifetime limit.", "counter", func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	}
+
+	for _, stat := range dbStats {
+		value := stat.value
+		registry.NewGaugeFunc(stat.name, stat.help, stat.kind, func() float64 { return value(db.Stats()) })
+	}
+
+	return m
+}
+
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		app.metrics.requestsInFlight.Inc()
+		defer app.metrics.requestsInFlight.Dec()
+
+		rec := newResponseRecorder(w)
+
+		defer func() {
+			route := app.contextGetRequestInfo(r).route
+			status := strconv.Itoa(rec.statusCode)
+
+			app.metrics.requests.Inc(r.Method, route, status)
+			app.metrics.requestDuration.Observe(time.Since(start).Seconds(), r.Method, route, status)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// instrumentedRouter records the pattern of the matched route in the request
+// info, so that metrics and access logs group requests by route rather than
+// by path.
+type instrumentedRouter struct {
+	*httprouter.Router
+}
+
+func (ir instrumentedRouter) HandlerFunc(method, path string, handler http.HandlerFunc) {
+	ir.Router.HandlerFunc(method, path, func(w http.ResponseWriter, r *http.Request) {
+		setRoutePattern(r, path)
+		handler(w, r)
+	})
+}
+
+func setRoutePattern(r *http.Request, pattern string) {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.route = pattern
+	}
+}
+
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	app.metrics.registry.Handler().ServeHTTP(w, r)
+}
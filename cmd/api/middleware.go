@@ -1,15 +1,18 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"golang.org/x/time/rate"
 	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/ratelimit"
 	"greenlight.aslan/internal/validator"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -27,68 +30,201 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) rateLimit(next http.Handler) http.Handler {
+// logRequest assigns every request an ID, reusing a well-formed X-Request-ID
+// sent by the client or a proxy, and writes one access log line once the
+// request has been handled.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
 
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			var err error
+
+			id, err = generateRequestID()
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
 
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
+		info := &requestInfo{id: id, route: "unmatched"}
+		r = app.contextSetRequestInfo(r, info)
 
-	go func() {
-		for {
-			time.Sleep(time.Minute)
+		w.Header().Set("X-Request-ID", id)
 
-			mu.Lock()
+		rec := newResponseRecorder(w)
 
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
+		defer func() {
+			properties := map[string]string{
+				"request_id":  info.id,
+				"method":      r.Method,
+				"route":       info.route,
+				"path":        r.URL.Path,
+				"status":      strconv.Itoa(rec.statusCode),
+				"bytes":       strconv.FormatInt(rec.bytes, 10),
+				"duration_ms": strconv.FormatFloat(float64(time.Since(start).Microseconds())/1000, 'f', 3, 64),
+				"remote_ip":   app.contextGetClientIP(r),
+			}
+
+			if info.userID != 0 {
+				properties["user_id"] = strconv.FormatInt(info.userID, 10)
+			}
+
+			if info.traceID != "" {
+				properties["trace_id"] = info.traceID
+				properties["span_id"] = info.spanID
 			}
 
-			mu.Unlock()
+			app.logger.PrintInfo("request", properties)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
 		}
-	}()
+	}
+
+	return true
+}
+
+func generateRequestID() (string, error) {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// responseRecorder captures the status code and size of a response for the
+// logging and metrics middleware. It passes Flush through so that streaming
+// handlers keep working behind it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode    int
+	bytes         int64
+	headerWritten bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+func (rr *responseRecorder) WriteHeader(statusCode int) {
+	if !rr.headerWritten {
+		rr.statusCode = statusCode
+		rr.headerWritten = true
+	}
+
+	rr.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.headerWritten = true
+
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += int64(n)
+
+	return n, err
+}
+
+func (rr *responseRecorder) Flush() {
+	if flusher, ok := rr.ResponseWriter.(http.Flusher); ok {
+		rr.headerWritten = true
+		flusher.Flush()
+	}
+}
+
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
+
+// newLimiter returns the limiter the API was configured with, or one that
+// keeps its buckets in memory.
+func (app *application) newLimiter() ratelimit.Limiter {
+	if app.limiter != nil {
+		return app.limiter
+	}
+
+	return ratelimit.NewMemory()
+}
+
+func (app *application) rateLimit(next http.Handler) http.Handler {
+	limiter := app.newLimiter()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
-			ip, _, err := net.SplitHostPort(r.RemoteAddr)
-			if err != nil {
-				app.serverErrorResponse(w, r, err)
+		settings := app.limiterSettings()
+
+		if settings.enabled {
+			key, limit, exempt := settings.rateLimitFor(r, app.contextGetUser(r), app.contextGetClientIP(r))
+			if exempt {
+				next.ServeHTTP(w, r)
 				return
 			}
 
-			mu.Lock()
-
-			if _, found := clients[ip]; !found {
-				clients[ip] = &client{
-					limiter: rate.NewLimiter(
-						rate.Limit(app.config.limiter.rps),
-						app.config.limiter.burst),
-				}
+			result, err := limiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				// Letting the request through keeps the API up while a shared
+				// limiter store is unavailable. Clients still see the limit,
+				// with nothing taken from it.
+				app.logError(r, err)
+				setRateLimitHeaders(w.Header(), ratelimit.Result{Allowed: true, Limit: limit.Burst, Remaining: limit.Burst})
+				next.ServeHTTP(w, r)
+				return
 			}
 
-			clients[ip].lastSeen = time.Now()
+			setRateLimitHeaders(w.Header(), result)
 
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			if !result.Allowed {
+				app.metrics.rateLimitRejected.Inc()
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
-
-			mu.Unlock()
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// setRateLimitHeaders tells clients how many requests they have left and,
+// once they have run out, how many seconds to wait before the next one.
+func setRateLimitHeaders(h http.Header, result ratelimit.Result) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+
+	if result.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	}
+}
+
+// authenticate identifies the user behind the credentials of a request.
+// Requests with bad credentials never reach rateLimit, so they are limited
+// here by client IP: once an IP runs out of failed attempts its credentials
+// aren't checked anymore, leaving nothing to learn from guessing.
 func (app *application) authenticate(next http.Handler) http.Handler {
+	failures := app.newLimiter()
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Authorization")
 
@@ -100,39 +236,131 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-			app.invalidAuthenticationTokenResponse(w, r)
-			return
-		}
+		settings := app.limiterSettings()
+		failureKey := "auth-failure|ip:" + app.contextGetClientIP(r)
+		failureLimit := ratelimit.Limit{Rate: settings.authFailureRPS, Burst: settings.authFailureBurst}
 
-		token := headerParts[1]
+		if settings.enabled {
+			result, err := failures.Peek(r.Context(), failureKey, failureLimit)
+			if err != nil {
+				app.logError(r, err)
+			} else if !result.Allowed {
+				setRateLimitHeaders(w.Header(), result)
+				app.metrics.rateLimitRejected.Inc()
+				app.rateLimitExceededResponse(w, r)
+				return
+			}
+		}
 
-		v := validator.New()
+		invalidCredentials := func() {
+			if settings.enabled {
+				result, err := failures.Allow(r.Context(), failureKey, failureLimit)
+				if err != nil {
+					app.logError(r, err)
+				} else {
+					setRateLimitHeaders(w.Header(), result)
+				}
+			}
 
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
 			app.invalidAuthenticationTokenResponse(w, r)
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 {
+			invalidCredentials()
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
-			default:
+		switch headerParts[0] {
+		case "Bearer":
+			token := headerParts[1]
+
+			if app.jwtKeys != nil && isJWT(token) {
+				claims, err := app.verifyJWT(token)
+				if err != nil {
+					invalidCredentials()
+					return
+				}
+
+				user, err := userFromJWT(claims)
+				if err != nil {
+					invalidCredentials()
+					return
+				}
+
+				r = app.contextSetUser(r, user)
+				break
+			}
+
+			v := validator.New()
+
+			if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+				invalidCredentials()
+				return
+			}
+
+			user, err := app.models.Users.GetForToken(r.Context(), data.ScopeAuthentication, token)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					invalidCredentials()
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+		case "ApiKey":
+			plaintext := headerParts[1]
+
+			v := validator.New()
+
+			if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+				invalidCredentials()
+				return
+			}
+
+			key, err := app.models.APIKeys.GetForPlaintext(r.Context(), plaintext)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					invalidCredentials()
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			user, err := app.models.Users.Get(r.Context(), key.UserID)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					invalidCredentials()
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			err = app.models.APIKeys.UpdateLastUsed(r.Context(), key.ID)
+			if err != nil {
 				app.serverErrorResponse(w, r, err)
+				return
 			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKey(r, key)
+		default:
+			invalidCredentials()
 			return
 		}
 
-		r = app.contextSetUser(r, user)
-
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
@@ -141,6 +369,14 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 			return
 		}
 
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
 		if !user.Activated {
 			app.inactiveAccountResponse(w, r)
 			return
@@ -148,6 +384,8 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 
 		next.ServeHTTP(w, r)
 	})
+
+	return app.requireAuthenticatedUser(fn)
 }
 
 func (app *application) requireOnlyAdmin(next http.HandlerFunc) http.HandlerFunc {
@@ -159,6 +397,47 @@ func (app *application) requireOnlyAdmin(next http.HandlerFunc) http.HandlerFunc
 			return
 		}
 
+		// API keys are scoped to the permissions they were created with, none
+		// of which cover the admin endpoints, even for keys owned by admins.
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireNoAPIKey rejects requests made with an API key. Keys only reach the
+// endpoints their permissions cover; managing the account behind a key takes
+// the user's own token.
+func (app *application) requireNoAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetAPIKey(r) != nil {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !data.PermissionsForRole(user.Role).Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		if key := app.contextGetAPIKey(r); key != nil && !key.Permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireActivatedUser(fn)
+}
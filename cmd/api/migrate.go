@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight.aslan/internal/jsonlog"
+	"greenlight.aslan/internal/migrate"
+	"greenlight.aslan/migrations"
+	"io"
+	"strconv"
+)
+
+const migrateUsage = "usage: greenlight migrate up | down [N] | status | goto N"
+
+// runMigrate carries out the migrate subcommand, writing the output of
+// status to w.
+func runMigrate(db *sql.DB, logger *jsonlog.Logger, w io.Writer, args []string) error {
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	ctx := context.Background()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = migrator.Up(ctx)
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations to roll back %q", args[1])
+			}
+		}
+		err = migrator.Down(ctx, steps)
+	case args[0] == "goto" && len(args) == 2:
+		var version int64
+		version, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		err = migrator.Goto(ctx, version)
+	case args[0] == "status" && len(args) == 1:
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(w, status)
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	logger.PrintInfo("database schema migrated", map[string]string{
+		"version": strconv.FormatInt(status.Version, 10),
+		"latest":  strconv.FormatInt(status.Latest, 10),
+	})
+
+	return nil
+}
+
+func printMigrationStatus(w io.Writer, status migrate.Status) {
+	dirty := ""
+	if status.Dirty {
+		dirty = " (dirty)"
+	}
+
+	fmt.Fprintf(w, "version %d of %d%s\n", status.Version, status.Latest, dirty)
+
+	for _, m := range status.Applied {
+		fmt.Fprintf(w, "  applied  %06d_%s\n", m.Version, m.Name)
+	}
+
+	for _, m := range status.Pending {
+		fmt.Fprintf(w, "  pending  %06d_%s\n", m.Version, m.Name)
+	}
+}
+
+// checkSchema makes sure the database has every migration this build was
+// shipped with, applying the missing ones when autoMigrate is set and failing
+// otherwise.
+func checkSchema(db *sql.DB, logger *jsonlog.Logger, autoMigrate bool) error {
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if status.Behind() && autoMigrate && !status.Dirty {
+		err = migrator.Up(ctx)
+		if err != nil && !errors.Is(err, migrate.ErrNoChange) {
+			return err
+		}
+
+		status, err = migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	properties := map[string]string{
+		"version": strconv.FormatInt(status.Version, 10),
+		"latest":  strconv.FormatInt(status.Latest, 10),
+	}
+
+	switch {
+	case status.Dirty:
+		return fmt.Errorf("database schema is dirty at version %d, fix it by hand before starting", status.Version)
+	case status.Behind():
+		return fmt.Errorf("database schema is at version %d but this build needs version %d, run \"greenlight migrate up\" or start with -auto-migrate", status.Version, status.Latest)
+	case status.Version > status.Latest:
+		logger.PrintInfo("database schema is newer than this build", properties)
+	default:
+		logger.PrintInfo("database schema is up to date", properties)
+	}
+
+	return nil
+}
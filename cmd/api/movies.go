@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"greenlight.aslan/internal/data"
 	"greenlight.aslan/internal/validator"
-	"log"
 	"net/http"
 )
 
@@ -24,10 +23,11 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:     input.Title,
+		Year:      input.Year,
+		Runtime:   input.Runtime,
+		Genres:    input.Genres,
+		CreatedBy: app.contextGetUser(r).ID,
 	}
 
 	v := validator.New()
@@ -37,7 +37,14 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Movies.Insert(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		return app.enqueueWebhooks(r.Context(), tx, data.EventMovieCreated, envelope{"movie": movie})
+	})
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -61,7 +68,7 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 
 	if err != nil {
 		switch {
@@ -82,13 +89,12 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Request) {
 
 	id, err := app.readIDParam(r)
-	log.Print(id)
 	if err != nil {
 		app.notFoundResponse(w, r)
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	movie, err := app.models.Movies.Get(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -131,7 +137,14 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Movies.Update(r.Context(), movie)
+		if err != nil {
+			return err
+		}
+
+		return app.enqueueWebhooks(r.Context(), tx, data.EventMovieUpdated, envelope{"movie": movie})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -156,7 +169,14 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		err := tx.Movies.Delete(r.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		return app.enqueueWebhooks(r.Context(), tx, data.EventMovieDeleted, envelope{"movie": envelope{"id": id}})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -199,7 +219,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(r.Context(), input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
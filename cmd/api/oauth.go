@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/oauth"
+	"greenlight.aslan/internal/trace"
+	"greenlight.aslan/internal/validator"
+	"net/http"
+	"time"
+)
+
+func loadOAuthProviders(cfg config, tracer *trace.Tracer) (map[string]*oauth.Provider, error) {
+	configs := cfg.oauth.providers
+
+	if cfg.oauth.configFile != "" {
+		fileConfigs, err := oauth.LoadConfigFile(cfg.oauth.configFile)
+		if err != nil {
+			return nil, err
+		}
+
+		configs = append(configs, fileConfigs...)
+	}
+
+	providers := make(map[string]*oauth.Provider)
+
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: trace.NewTransport(tracer, nil),
+	}
+
+	for _, providerConfig := range configs {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oauth.NewProvider(ctx, providerConfig, client)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+
+		providers[provider.Name()] = provider
+	}
+
+	return providers, nil
+}
+
+func (app *application) readOAuthProvider(r *http.Request) (*oauth.Provider, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	provider, found := app.oauthProviders[params.ByName("provider")]
+	return provider, found
+}
+
+func (app *application) oauthAuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	provider, found := app.readOAuthProvider(r)
+	if !found {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	state := &data.OAuthState{
+		Provider: provider.Name(),
+		Expiry:   time.Now().Add(10 * time.Minute),
+	}
+
+	var err error
+
+	for _, dst := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		*dst, err = oauth.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.models.Identities.InsertState(r.Context(), state)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	authorizationURL := provider.AuthCodeURL(state.State, state.Nonce, state.CodeVerifier)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": authorizationURL}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, found := app.readOAuthProvider(r)
+	if !found {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	if providerError := qs.Get("error"); providerError != "" {
+		app.errorResponse(w, r, http.StatusBadRequest, "identity provider returned an error: "+providerError)
+		return
+	}
+
+	code := app.readString(qs, "code", "")
+	stateParam := app.readString(qs, "state", "")
+
+	v := validator.New()
+
+	v.Check(code != "", "code", "must be provided")
+	v.Check(stateParam != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	state, err := app.models.Identities.ConsumeState(r.Context(), provider.Name(), stateParam)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired state")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oauth.ErrCodeRejected), errors.Is(err, oauth.ErrInvalidIDToken), errors.Is(err, oauth.ErrMissingSubject):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.Identities.GetUser(r.Context(), provider.Name(), identity.Subject)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		user, err = app.linkOAuthIdentity(r.Context(), provider.Name(), identity, app.readLanguage(r), v)
+		if err != nil {
+			switch {
+			// A concurrent first sign in with the same identity got there
+			// first, so signing in again finds what it linked.
+			case errors.Is(err, data.ErrDuplicateEmail), errors.Is(err, data.ErrDuplicateIdentity):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	token, err := app.newAuthenticationToken(r.Context(), user)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// linkOAuthIdentity attaches a new external identity to the user with the
+// same verified email address, or registers a new activated user for it.
+func (app *application) linkOAuthIdentity(ctx context.Context, provider string, identity *oauth.Identity, language string, v *validator.Validator) (*data.User, error) {
+	v.Check(identity.Email != "", "email", "must be shared by the identity provider")
+	v.Check(identity.EmailVerified, "email", "must be verified by the identity provider")
+
+	if !v.Valid() {
+		return nil, nil
+	}
+
+	user, err := app.models.Users.GetByEmail(ctx, identity.Email)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		user, err = app.registerOAuthUser(ctx, identity, language, v)
+		if err != nil || !v.Valid() {
+			return nil, err
+		}
+	}
+
+	var revoked map[string]time.Time
+
+	err = app.models.Transaction(ctx, func(tx data.Models) error {
+		// Nobody has proved they own the address of an account that was never
+		// activated, so it may have been registered by someone else in wait
+		// for the owner. The owner proves it now: the account becomes theirs,
+		// and the password and tokens chosen by whoever registered it go.
+		if !user.Activated {
+			var err error
+
+			revoked, err = claimUnactivatedUser(ctx, tx, user, identity)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Identities.Insert(ctx, &data.UserIdentity{
+			Provider: provider,
+			Subject:  identity.Subject,
+			UserID:   user.ID,
+			Email:    identity.Email,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app.denyJWTs(revoked)
+
+	return user, nil
+}
+
+func claimUnactivatedUser(ctx context.Context, tx data.Models, user *data.User, identity *oauth.Identity) (map[string]time.Time, error) {
+	user.Password.SetUnusable()
+
+	if identity.Name != "" {
+		user.Name = identity.Name
+	}
+
+	user.Activated = true
+
+	err := tx.Users.Update(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Tokens.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return revokeUserTokens(ctx, tx, user.ID)
+}
+
+func (app *application) registerOAuthUser(ctx context.Context, identity *oauth.Identity, language string, v *validator.Validator) (*data.User, error) {
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     identity.Email,
+		Activated: true,
+		Language:  language,
+	}
+
+	user.Password.SetUnusable()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		return nil, nil
+	}
+
+	err := app.models.Users.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (app *application) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	identities, err := app.models.Identities.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"identities": identities}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
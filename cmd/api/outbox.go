@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/trace"
+	"greenlight.aslan/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	outboxBatchSize = 10
+	outboxLease     = 5 * time.Minute
+)
+
+func (app *application) enqueueEmail(ctx context.Context, user *data.User, templateFile string, templateData map[string]any) error {
+	return app.enqueueEmailTx(ctx, app.models, user, templateFile, templateData)
+}
+
+func (app *application) enqueueEmailTx(ctx context.Context, models data.Models, user *data.User, templateFile string, templateData map[string]any) error {
+	email := &data.OutboxEmail{
+		Recipient: user.Email,
+		Template:  app.templates.Lookup(templateFile, user.Language),
+		Data:      templateData,
+	}
+
+	return models.Outbox.Insert(ctx, email)
+}
+
+func (app *application) startOutboxWorkers(ctx context.Context) {
+	for i := 0; i < app.config.outbox.workers; i++ {
+		app.background(func() {
+			ticker := time.NewTicker(app.config.outbox.pollInterval)
+			defer ticker.Stop()
+
+			for {
+				app.deliverOutbox(ctx)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		})
+	}
+}
+
+func (app *application) deliverOutbox(ctx context.Context) {
+	for ctx.Err() == nil {
+		emails, err := app.models.Outbox.Claim(ctx, outboxBatchSize, outboxLease)
+		if err != nil {
+			if !errors.Is(err, data.ErrQueryCanceled) {
+				app.logger.PrintError(err, nil)
+			}
+			return
+		}
+
+		if len(emails) == 0 {
+			return
+		}
+
+		for _, email := range emails {
+			app.deliverEmail(email)
+		}
+	}
+}
+
+func (app *application) deliverEmail(email *data.OutboxEmail) {
+	ctx, span := app.tracer.Start(context.Background(), "outbox.deliver", trace.KindInternal,
+		trace.Int("email.id", email.ID),
+		trace.String("email.template", email.Template),
+	)
+	defer span.End()
+
+	err := app.sendOutboxEmail(ctx, email)
+	if err == nil {
+		app.metrics.emailsSent.Inc("success")
+
+		err = app.models.Outbox.MarkSent(ctx, email.ID)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+		return
+	}
+
+	app.metrics.emailsSent.Inc("failure")
+	span.RecordError(err)
+
+	attempts := email.Attempts + 1
+	dead := attempts >= app.config.outbox.maxAttempts
+	nextAttemptAt := time.Now().Add(exponentialBackoff(attempts, app.config.outbox.backoff, app.config.outbox.maxBackoff))
+
+	properties := map[string]string{
+		"email_id": strconv.FormatInt(email.ID, 10),
+		"template": email.Template,
+		"attempts": strconv.Itoa(attempts),
+	}
+
+	addTraceProperties(properties, span)
+
+	if dead {
+		properties["status"] = data.OutboxStatusDead
+	}
+
+	app.logger.PrintError(err, properties)
+
+	err = app.models.Outbox.MarkFailed(ctx, email.ID, err, nextAttemptAt, dead)
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+}
+
+func (app *application) sendOutboxEmail(ctx context.Context, email *data.OutboxEmail) (err error) {
+	_, span := app.tracer.Start(ctx, "mailer.send", trace.KindClient,
+		trace.String("mail.backend", app.config.mailer.backend),
+	)
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%s", rec)
+		}
+
+		span.RecordError(err)
+		span.End()
+	}()
+
+	return app.mailer.Send(email.Recipient, email.Template, email.Data)
+}
+
+func (app *application) listOutboxEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Status = app.readString(qs, "status", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "next_attempt_at", "attempts",
+		"-id", "-created_at", "-next_attempt_at", "-attempts"}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, data.OutboxStatusPending, data.OutboxStatusSent, data.OutboxStatusDead), "status", "invalid status value")
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	emails, metadata, err := app.models.Outbox.GetAll(r.Context(), input.Status, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"emails": emails, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	email, err := app.models.Outbox.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"email": email}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) retryOutboxEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Outbox.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Outbox.Retry(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.errorResponse(w, r, http.StatusConflict, "only dead emails can be retried")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "email queued for redelivery"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/ratelimit"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rateLimitPolicy gives the requests to matching routes by matching
+// principals their own limit. Routes are matched like httprouter does it:
+// :name matches a single path segment and a trailing *name the rest of the
+// path. An empty method matches every method.
+type rateLimitPolicy struct {
+	name      string
+	method    string
+	route     string
+	principal string
+	limit     ratelimit.Limit
+}
+
+// parseRateLimitPolicy parses a policy such as
+// "route=POST /v1/movies,principal=user,rps=0.5,burst=2". The principal is
+// anonymous, user (any authenticated user) or role:<name>, and is left out
+// to match everyone.
+func parseRateLimitPolicy(value string) (rateLimitPolicy, error) {
+	policy := rateLimitPolicy{name: value}
+
+	var hasRPS, hasBurst bool
+
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return rateLimitPolicy{}, fmt.Errorf("invalid rate limit policy setting %q", pair)
+		}
+
+		switch key {
+		case "route":
+			fields := strings.Fields(val)
+			switch len(fields) {
+			case 1:
+				policy.route = fields[0]
+			case 2:
+				policy.method, policy.route = strings.ToUpper(fields[0]), fields[1]
+			default:
+				return rateLimitPolicy{}, fmt.Errorf("invalid rate limit policy route %q", val)
+			}
+
+			if !strings.HasPrefix(policy.route, "/") {
+				return rateLimitPolicy{}, fmt.Errorf("rate limit policy route %q must start with /", val)
+			}
+		case "principal":
+			if val != "anonymous" && val != "user" && (!strings.HasPrefix(val, "role:") || val == "role:") {
+				return rateLimitPolicy{}, fmt.Errorf("rate limit policy principal %q must be anonymous, user or role:<name>", val)
+			}
+			policy.principal = val
+		case "rps":
+			rps, err := strconv.ParseFloat(val, 64)
+			if err != nil || rps <= 0 {
+				return rateLimitPolicy{}, fmt.Errorf("rate limit policy rps %q must be a number greater than zero", val)
+			}
+			policy.limit.Rate, hasRPS = rps, true
+		case "burst":
+			burst, err := strconv.Atoi(val)
+			if err != nil || burst <= 0 {
+				return rateLimitPolicy{}, fmt.Errorf("rate limit policy burst %q must be an integer greater than zero", val)
+			}
+			policy.limit.Burst, hasBurst = burst, true
+		default:
+			return rateLimitPolicy{}, fmt.Errorf("unknown rate limit policy setting %q", key)
+		}
+	}
+
+	if policy.route == "" || !hasRPS || !hasBurst {
+		return rateLimitPolicy{}, fmt.Errorf("rate limit policy %q needs a route, rps and burst", value)
+	}
+
+	return policy, nil
+}
+
+func (p rateLimitPolicy) matches(r *http.Request, user *data.User) bool {
+	if p.method != "" && p.method != r.Method {
+		return false
+	}
+
+	switch {
+	case p.principal == "anonymous" && !user.IsAnonymous():
+		return false
+	case p.principal == "user" && user.IsAnonymous():
+		return false
+	case strings.HasPrefix(p.principal, "role:") && (user.IsAnonymous() || user.Role != strings.TrimPrefix(p.principal, "role:")):
+		return false
+	}
+
+	return matchRoute(p.route, r.URL.Path)
+}
+
+func matchRoute(pattern, path string) bool {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, segment := range patternSegments {
+		switch {
+		case strings.HasPrefix(segment, "*"):
+			return i == len(patternSegments)-1
+		case i >= len(pathSegments):
+			return false
+		case strings.HasPrefix(segment, ":"):
+			if pathSegments[i] == "" {
+				return false
+			}
+		case segment != pathSegments[i]:
+			return false
+		}
+	}
+
+	return len(patternSegments) == len(pathSegments)
+}
+
+// rateLimitFor picks the bucket and the limit for a request: the first
+// matching policy, or else the global limit. Users are limited by account and
+// anonymous clients by IP address. Activated users with an exempt role
+// aren't limited.
+func (l limiterConfig) rateLimitFor(r *http.Request, user *data.User, ip string) (key string, limit ratelimit.Limit, exempt bool) {
+	principal := "ip:" + ip
+
+	if !user.IsAnonymous() {
+		for _, role := range strings.Split(l.exemptRoles, ",") {
+			if user.Activated && strings.TrimSpace(role) == user.Role {
+				return "", ratelimit.Limit{}, true
+			}
+		}
+
+		principal = "user:" + strconv.FormatInt(user.ID, 10)
+	}
+
+	for _, policy := range l.policies {
+		if policy.matches(r, user) {
+			return policy.name + "|" + principal, policy.limit, false
+		}
+	}
+
+	return principal, ratelimit.Limit{Rate: l.rps, Burst: l.burst}, false
+}
@@ -2,40 +2,83 @@ package main
 
 import (
 	"github.com/julienschmidt/httprouter"
+	"greenlight.aslan/internal/data"
 	"net/http"
 )
 
 func (app *application) routes() http.Handler {
 
-	router := httprouter.New()
+	router := instrumentedRouter{httprouter.New()}
 
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	// app.requireOnlyAdmin(app.requireActivatedUser())
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/live", app.healthcheckHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler)
+
+	if app.config.metrics.enabled {
+		router.HandlerFunc(http.MethodGet, "/metrics", app.metricsHandler)
+	}
+
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission(data.PermissionMoviesWrite, app.createMovieHandler))
 	// app.requireActivatedUser()
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
-	// app.requireOnlyAdmin(app.requireActivatedUser())
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	// app.requireOnlyAdmin(app.requireActivatedUser())
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.movieOrStreamHandler(app.requirePermission(data.PermissionMoviesRead, app.streamMoviesHandler), app.showMovieHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.updateMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission(data.PermissionMoviesWrite, app.deleteMovieHandler))
 	// app.requireActivatedUser()
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMoviesHandler)
 
-	// app.requireActivatedUser()
-	router.HandlerFunc(http.MethodPost, "/v1/trailers", app.createTrailerHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/trailers", app.requirePermission(data.PermissionTrailersWrite, app.createTrailerHandler))
 
-	//app.requireActivatedUser()
-	router.HandlerFunc(http.MethodPost, "/v1/directors", app.createDirectorHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/directors", app.requireActivatedUser(app.listDirectorsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/directors", app.requirePermission(data.PermissionDirectorsWrite, app.createDirectorHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/directors", app.requirePermission(data.PermissionDirectorsRead, app.listDirectorsHandler))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me", app.requireNoAPIKey(app.requireAuthenticatedUser(app.deleteUserHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/deletion", app.requireNoAPIKey(app.requireAuthenticatedUser(app.confirmUserDeletionHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/deletion", app.requireNoAPIKey(app.requireAuthenticatedUser(app.cancelUserDeletionHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/export", app.requireNoAPIKey(app.requireActivatedUser(app.exportUserDataHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/identities", app.requireNoAPIKey(app.requireActivatedUser(app.listIdentitiesHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireNoAPIKey(app.requireActivatedUser(app.listAPIKeysHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireNoAPIKey(app.requireActivatedUser(app.createAPIKeyHandler)))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireNoAPIKey(app.requireActivatedUser(app.deleteAPIKeyHandler)))
+
+	router.HandlerFunc(http.MethodGet, "/v1/exports/:token", app.downloadDataExportHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks", app.requirePermission(data.PermissionWebhooksManage, app.listWebhooksHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks", app.requirePermission(data.PermissionWebhooksManage, app.createWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id", app.requirePermission(data.PermissionWebhooksManage, app.showWebhookHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission(data.PermissionWebhooksManage, app.updateWebhookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission(data.PermissionWebhooksManage, app.deleteWebhookHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission(data.PermissionWebhooksManage, app.listWebhookDeliveriesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", app.requirePermission(data.PermissionWebhooksManage, app.redeliverWebhookHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/events", app.requirePermission(data.PermissionEventsRead, app.listEventsHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/unlock", app.requireOnlyAdmin(app.requireActivatedUser(app.unlockUserHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails", app.requireOnlyAdmin(app.requireActivatedUser(app.listOutboxEmailsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/emails/:id", app.requireOnlyAdmin(app.requireActivatedUser(app.showOutboxEmailHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/emails/:id/retry", app.requireOnlyAdmin(app.requireActivatedUser(app.retryOutboxEmailHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs", app.requireOnlyAdmin(app.requireActivatedUser(app.listJobsHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/jobs/:id", app.requireOnlyAdmin(app.requireActivatedUser(app.showJobHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/jobs/:id/retry", app.requireOnlyAdmin(app.requireActivatedUser(app.retryJobHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/jobs/:id/cancel", app.requireOnlyAdmin(app.requireActivatedUser(app.cancelJobHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/templates", app.requireOnlyAdmin(app.requireActivatedUser(app.listTemplatesHandler)))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/templates/:name", app.requireOnlyAdmin(app.requireActivatedUser(app.previewTemplateHandler)))
 
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.deleteAuthenticationTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/:provider/authorize", app.oauthAuthorizeHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oauth/:provider/callback", app.oauthCallbackHandler)
+
+	handler := app.recoverPanic(app.authenticate(app.rateLimit(router)))
+
+	if app.config.metrics.enabled {
+		handler = app.recordMetrics(handler)
+	}
 
-	// app.authenticate()
-	return app.recoverPanic(app.rateLimit(router))
+	return app.resolveClientIP(app.logRequest(app.traceRequest(handler)))
 }
@@ -11,26 +11,60 @@ import (
 	"time"
 )
 
+const serverWriteTimeout = 30 * time.Second
+
 func (app *application) serve() error {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
 		Handler:      app.routes(),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: serverWriteTimeout,
+		ConnContext:  app.contextSetConn,
 	}
 
+	srv.RegisterOnShutdown(app.movieEvents.close)
+
 	shutdownError := make(chan error)
 
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+
+	err := app.startJobs(workerCtx, jobCtx)
+	if err != nil {
+		return err
+	}
+
+	app.startOutboxWorkers(workerCtx)
+
+	if app.jwtDenylist != nil {
+		app.startJWTDenylistRefresh(workerCtx)
+	}
+
+	err = app.listenForEvents(workerCtx)
+	if err != nil {
+		return err
+	}
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 
+		// Readiness probes fail from here on, so that load balancers stop
+		// sending requests while the server drains.
+		app.shuttingDown.Store(true)
+
 		app.logger.PrintInfo("shutting down server", map[string]string{
 			"signal": s.String(),
 		})
 
+		// Requests keep being served until the load balancers have noticed.
+		time.Sleep(app.config.drainDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 		defer cancel()
 
@@ -43,7 +77,26 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		stopWorkers()
+
+		// Running jobs get what is left of the shutdown timeout to finish.
+		go func() {
+			<-ctx.Done()
+			stopJobs()
+		}()
+
 		app.wg.Wait()
+
+		// The tracer gets a timeout of its own, as draining may have used
+		// up the shutdown one.
+		tracerCtx, tracerCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer tracerCancel()
+
+		err = app.tracer.Shutdown(tracerCtx)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
 		shutdownError <- nil
 	}()
 
@@ -52,7 +105,7 @@ func (app *application) serve() error {
 		"env":  app.config.env,
 	})
 
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
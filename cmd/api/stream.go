@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/lib/pq"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/validator"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	eventsChannel        = "events"
+	movieStreamBatchSize = 100
+	movieStreamKeepAlive = 15 * time.Second
+	movieStreamRetry     = time.Second
+
+	// Streams push back the server's write timeout before every write, so
+	// that only a client that stops reading is cut off.
+	movieStreamWriteTimeout = movieStreamKeepAlive + serverWriteTimeout
+
+	// Streams are still ended after a while, so that clients authenticate
+	// again. EventSource clients reconnect by themselves and resume from the
+	// Last-Event-ID they were last sent.
+	movieStreamMaxDuration = 30 * time.Minute
+)
+
+// eventBroker wakes up stream handlers when Postgres reports new events.
+// Subscribers only receive a signal and read the events themselves, so a
+// slow client never holds up the others.
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan struct{}]bool
+	closed      bool
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: make(map[chan struct{}]bool)}
+}
+
+func (b *eventBroker) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.subscribers[ch] = true
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBroker) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close ends every subscription, and any made afterwards, so that open
+// streams return during a graceful shutdown.
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// listenForEvents subscribes to the notifications sent by the events table
+// trigger, so that streams on every instance see writes made on any of them.
+func (app *application) listenForEvents(ctx context.Context) error {
+	listener := pq.NewListener(app.config.db.dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err := listener.Listen(eventsChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	app.background(func() {
+		defer listener.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification is sent after the connection has been
+				// re-established, when notifications may have been lost.
+				if n == nil || n.Extra == data.EventAggregateMovie {
+					app.movieEvents.notify()
+				}
+			case <-time.After(90 * time.Second):
+				// The ping waits for a reply read by the listener, which
+				// needs Notify drained, so it can't block this loop.
+				app.background(func() {
+					err := listener.Ping()
+					if err != nil && ctx.Err() == nil {
+						app.logger.PrintError(err, nil)
+					}
+				})
+			}
+		}
+	})
+
+	return nil
+}
+
+// movieOrStreamHandler serves /v1/movies/stream, which httprouter can't
+// register next to /v1/movies/:id.
+func (app *application) movieOrStreamHandler(stream, show http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "stream" {
+			setRoutePattern(r, "/v1/movies/stream")
+			stream(w, r)
+			return
+		}
+
+		show(w, r)
+	}
+}
+
+func (app *application) streamMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		app.serverErrorResponse(w, r, errors.New("response writer does not support streaming"))
+		return
+	}
+
+	genres := app.readCSV(r.URL.Query(), "genres", []string{})
+
+	lastEventID, resume, err := readLastEventID(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	updates, unsubscribe := app.movieEvents.subscribe()
+	defer unsubscribe()
+
+	if !resume {
+		lastEventID, err = app.models.Events.LatestID(r.Context())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", movieStreamRetry.Milliseconds())
+
+	keepAlive := time.NewTicker(movieStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	deadline := time.NewTimer(movieStreamMaxDuration)
+	defer deadline.Stop()
+
+	for {
+		err = app.extendWriteDeadline(r, movieStreamWriteTimeout)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+			return
+		}
+
+		lastEventID, err = app.writeMovieEvents(r.Context(), w, lastEventID, genres)
+		if err != nil {
+			if !errors.Is(err, data.ErrQueryCanceled) {
+				app.logger.PrintError(err, nil)
+			}
+			return
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-deadline.C:
+			return
+		case _, ok := <-updates:
+			if !ok {
+				return
+			}
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+	}
+}
+
+// writeMovieEvents writes every movie event after the given ID that matches
+// genres and returns the ID of the last event read.
+func (app *application) writeMovieEvents(ctx context.Context, w io.Writer, after int64, genres []string) (int64, error) {
+	for {
+		events, err := app.models.Events.GetAfterForAggregate(ctx, data.EventAggregateMovie, after, movieStreamBatchSize)
+		if err != nil {
+			return after, err
+		}
+
+		for _, event := range events {
+			after = event.ID
+
+			if !movieEventHasGenres(event, genres) {
+				continue
+			}
+
+			err = writeServerSentEvent(w, event)
+			if err != nil {
+				return after, err
+			}
+		}
+
+		if len(events) < movieStreamBatchSize {
+			return after, nil
+		}
+	}
+}
+
+func writeServerSentEvent(w io.Writer, event *data.Event) error {
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, js)
+	return err
+}
+
+func movieEventHasGenres(event *data.Event, genres []string) bool {
+	if len(genres) == 0 {
+		return true
+	}
+
+	var movie struct {
+		Genres []string `json:"genres"`
+	}
+
+	err := json.Unmarshal(event.Payload, &movie)
+	if err != nil {
+		return false
+	}
+
+	for _, genre := range genres {
+		if !validator.PermittedValue(genre, movie.Genres...) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// readLastEventID returns the event ID a client wants to resume after, taken
+// from the Last-Event-ID header sent on reconnects or, for the first
+// connection, the last_event_id query string parameter.
+func readLastEventID(r *http.Request) (int64, bool, error) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = r.URL.Query().Get("last_event_id")
+	}
+
+	if value == "" {
+		return 0, false, nil
+	}
+
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, false, errors.New("invalid Last-Event-ID")
+	}
+
+	return id, true, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.aslan/internal/mailer"
+	"greenlight.aslan/internal/validator"
+	"net/http"
+	"time"
+)
+
+var templateSamples = map[string]map[string]any{
+	"user_welcome": {
+		"activationToken": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"userID":          1,
+	},
+	"account_locked": {
+		"name":        "Jane Doe",
+		"lockedUntil": time.Date(2024, time.January, 1, 12, 30, 0, 0, time.UTC).Format(time.RFC1123),
+	},
+	"data_export": {
+		"name":        "Jane Doe",
+		"downloadURL": "http://localhost:4000/v1/exports/Y3QMGX3PJ3WLRL2YRTQGQ6KRHU",
+		"expiry":      time.Date(2024, time.January, 2, 12, 0, 0, 0, time.UTC).Format(time.RFC1123),
+	},
+	"account_deletion": {
+		"name":         "Jane Doe",
+		"scheduledFor": time.Date(2024, time.January, 31, 12, 0, 0, 0, time.UTC).Format(time.RFC1123),
+	},
+}
+
+func (app *application) listTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"templates": app.templates.List()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) previewTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	name := httprouter.ParamsFromContext(r.Context()).ByName("name")
+
+	qs := r.URL.Query()
+
+	locale := app.readString(qs, "locale", mailer.DefaultLocale)
+	format := app.readString(qs, "format", "json")
+
+	v := validator.New()
+
+	v.Check(validator.PermittedValue(format, "json", "html", "text"), "format", "must be json, html or text")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	templateFile := app.templates.Lookup(name+".tmpl", locale)
+
+	msg, err := app.templates.Render(app.config.smtp.sender, "jane@example.com", templateFile, templateSamples[name])
+	if err != nil {
+		switch {
+		case errors.Is(err, mailer.ErrTemplateNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	switch format {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(msg.HTMLBody))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(msg.PlainBody))
+	default:
+		env := envelope{
+			"template":   msg.Template,
+			"subject":    msg.Subject,
+			"plain_body": msg.PlainBody,
+			"html_body":  msg.HTMLBody,
+		}
+
+		err = app.writeJSON(w, http.StatusOK, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+	}
+}
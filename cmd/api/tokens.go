@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"greenlight.aslan/internal/data"
 	"greenlight.aslan/internal/validator"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -30,11 +32,62 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.Users.GetByEmail(input.Email)
+	ip := app.contextGetClientIP(r)
+
+	lockedUntil, err := app.models.LoginAttempts.GetLock(r.Context(), input.Email)
+	switch {
+	case err == nil:
+		app.accountLockedResponse(w, r, lockedUntil)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	since := time.Now().Add(-app.config.login.window)
+
+	ipFailures, err := app.models.LoginAttempts.CountForIP(r.Context(), ip, since)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.config.login.ipMaxAttempts > 0 && ipFailures >= app.config.login.ipMaxAttempts {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	// The attempt is recorded, and counted in the same step, before the
+	// password is checked, so that concurrent guesses can't all get in under
+	// the limit. A successful login clears it again.
+	var attempts int
+
+	err = app.models.Transaction(r.Context(), func(tx data.Models) error {
+		var err error
+		attempts, err = tx.LoginAttempts.Record(r.Context(), input.Email, ip, since)
+		return err
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.config.login.maxAttempts > 0 && attempts > app.config.login.maxAttempts {
+		app.failedLoginResponse(w, r, input.Email, ip, nil, attempts)
+		return
+	}
+
+	select {
+	case <-time.After(app.loginDelay(attempts - 1)):
+	case <-r.Context().Done():
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(r.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			app.invalidCredentialsResponse(w, r)
+			app.failedLoginResponse(w, r, input.Email, ip, nil, attempts)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -48,11 +101,17 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	if !match {
-		app.invalidCredentialsResponse(w, r)
+		app.failedLoginResponse(w, r, input.Email, ip, user, attempts)
+		return
+	}
+
+	err = app.models.LoginAttempts.DeleteAllForEmail(r.Context(), input.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.newAuthenticationToken(r.Context(), user)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -63,3 +122,97 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+func (app *application) newAuthenticationToken(ctx context.Context, user *data.User) (*data.Token, error) {
+	if app.config.token.mode == "jwt" {
+		return app.newJWT(ctx, user)
+	}
+
+	return app.models.Tokens.New(ctx, user.ID, app.config.token.ttl, data.ScopeAuthentication)
+}
+
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if app.contextGetUser(r).IsAnonymous() {
+		app.authenticationRequiredResponse(w, r)
+		return
+	}
+
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	token := headerParts[1]
+
+	if app.jwtKeys != nil && isJWT(token) {
+		claims, err := app.verifyJWT(token)
+		if err != nil {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		err = app.models.RevokedTokens.Insert(r.Context(), claims.ID, claims.Expiry())
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.jwtDenylist.Add(claims.ID, claims.Expiry())
+	} else {
+		err := app.models.Tokens.DeleteForPlaintext(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "authentication token successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// failedLoginResponse locks the account once failures, the recorded attempts
+// in the window, reach the limit.
+func (app *application) failedLoginResponse(w http.ResponseWriter, r *http.Request, email, ip string, user *data.User, failures int) {
+	if app.config.login.maxAttempts < 1 || failures < app.config.login.maxAttempts {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	lockedUntil := time.Now().Add(app.config.login.lockout)
+
+	err := app.models.LoginAttempts.Lock(r.Context(), email, lockedUntil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("account locked", map[string]string{
+		"email": email,
+		"ip":    ip,
+	})
+
+	if user != nil {
+		data := map[string]any{
+			"name":        user.Name,
+			"lockedUntil": lockedUntil.UTC().Format(time.RFC1123),
+		}
+
+		err = app.enqueueEmail(r.Context(), user, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+
+	app.accountLockedResponse(w, r, lockedUntil)
+}
+
+func (app *application) loginDelay(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+
+	return exponentialBackoff(failures, app.config.login.delay, app.config.login.maxDelay)
+}
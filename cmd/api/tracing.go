@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight.aslan/internal/jsonlog"
+	"greenlight.aslan/internal/trace"
+	"net/http"
+	"os"
+)
+
+func newTracer(cfg config, logger *jsonlog.Logger) (*trace.Tracer, error) {
+	var exporter trace.Exporter
+
+	switch cfg.trace.exporter {
+	case "none":
+	case "stdout":
+		exporter = trace.NewWriterExporter(os.Stdout)
+	case "file":
+		f, err := os.OpenFile(cfg.trace.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		exporter = trace.NewWriterExporter(f)
+	case "otlp":
+		exporter = trace.NewOTLPExporter(cfg.trace.otlpEndpoint)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.trace.exporter)
+	}
+
+	if cfg.trace.sampleRatio < 0 || cfg.trace.sampleRatio > 1 {
+		return nil, errors.New("trace sample ratio must be between 0 and 1")
+	}
+
+	return trace.NewTracer(cfg.trace.serviceName, cfg.trace.sampleRatio, exporter, func(err error) {
+		logger.PrintError(err, nil)
+	}), nil
+}
+
+// traceRequest starts the server span for a request, continuing the trace
+// of the caller when a traceparent header was sent. Its IDs are recorded in
+// the request info for the access log.
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if parent, ok := trace.Extract(r.Header); ok {
+			ctx = trace.ContextWithRemoteSpanContext(ctx, parent)
+		}
+
+		ctx, span := app.tracer.Start(ctx, r.Method, trace.KindServer,
+			trace.String("http.method", r.Method),
+			trace.String("http.target", r.URL.Path),
+		)
+
+		info := app.contextGetRequestInfo(r)
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			info.traceID = sc.TraceID.String()
+			info.spanID = sc.SpanID.String()
+		}
+
+		rec := newResponseRecorder(w)
+
+		defer func() {
+			span.SetName(r.Method + " " + info.route)
+			span.SetAttributes(
+				trace.String("http.route", info.route),
+				trace.Int("http.status_code", int64(rec.statusCode)),
+			)
+
+			if rec.statusCode >= 500 {
+				span.RecordError(errors.New(http.StatusText(rec.statusCode)))
+			}
+
+			span.End()
+		}()
+
+		next.ServeHTTP(rec, r.WithContext(ctx))
+	})
+}
+
+// addTraceProperties adds the IDs of span to a set of log properties, so
+// that log entries can be matched up with traces.
+func addTraceProperties(properties map[string]string, span *trace.Span) {
+	if sc := span.SpanContext(); sc.IsValid() {
+		properties["trace_id"] = sc.TraceID.String()
+		properties["span_id"] = sc.SpanID.String()
+	}
+}
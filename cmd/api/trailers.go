@@ -23,9 +23,10 @@ func (app *application) createTrailerHandler(w http.ResponseWriter, r *http.Requ
 		Trailer_name: input.Trailer_name,
 		Duration:     input.Duration,
 		Premier_date: input.Premier_date,
+		CreatedBy:    app.contextGetUser(r).ID,
 	}
 
-	err = app.models.Trailers.Insert(trailer)
+	err = app.models.Trailers.Insert(r.Context(), trailer)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"greenlight.aslan/internal/data"
+	"greenlight.aslan/internal/trace"
+	"greenlight.aslan/internal/validator"
+	"greenlight.aslan/internal/webhook"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type deliverWebhookPayload struct {
+	WebhookID  int64 `json:"webhook_id"`
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// enqueueWebhooks records a delivery for every webhook subscribed to event
+// and queues a job to send it. It is meant to run in the same transaction
+// as the write that raised the event, so deliveries exist if and only if the
+// write was committed.
+func (app *application) enqueueWebhooks(ctx context.Context, tx data.Models, event string, eventData any) error {
+	webhooks, err := tx.Webhooks.GetAllForEvent(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(envelope{
+		"event":      event,
+		"created_at": time.Now().UTC(),
+		"data":       eventData,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range webhooks {
+		delivery := &data.WebhookDelivery{
+			WebhookID: hook.ID,
+			Event:     event,
+			Payload:   payload,
+		}
+
+		err = tx.Webhooks.InsertDelivery(ctx, delivery)
+		if err != nil {
+			return err
+		}
+
+		_, err = app.enqueueJobTx(ctx, tx, jobDeliverWebhook, deliverWebhookPayload{WebhookID: hook.ID, DeliveryID: delivery.ID}, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (app *application) deliverWebhookJob(ctx context.Context, payload deliverWebhookPayload) error {
+	hook, err := app.models.Webhooks.Get(ctx, payload.WebhookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	delivery, err := app.models.Webhooks.GetDelivery(ctx, payload.WebhookID, payload.DeliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+
+	if !hook.Active {
+		return app.models.Webhooks.RecordAttempt(ctx, delivery.ID, 0, errors.New("webhook is disabled"))
+	}
+
+	var transport http.RoundTripper
+	if !app.config.webhooks.allowPrivate {
+		transport = webhook.NewTransport()
+	}
+
+	client := &http.Client{
+		Timeout:   app.config.webhooks.timeout,
+		Transport: trace.NewTransport(app.tracer, transport),
+	}
+
+	status, sendErr := webhook.Send(ctx, client, webhook.Request{
+		URL:        hook.URL,
+		Secret:     hook.Secret,
+		Event:      delivery.Event,
+		DeliveryID: strconv.FormatInt(delivery.ID, 10),
+		Body:       delivery.Payload,
+	})
+
+	err = app.models.Webhooks.RecordAttempt(ctx, delivery.ID, status, sendErr)
+	if err != nil {
+		return err
+	}
+
+	return sendErr
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func (app *application) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.models.Webhooks.GetAll(r.Context())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		Secret: input.Secret,
+		Active: true,
+	}
+
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	if hook.Secret == "" {
+		hook.Secret, err = generateWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	v := validator.New()
+
+	if app.validateWebhook(r.Context(), v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Insert(r.Context(), hook)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", hook.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": hook, "secret": hook.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Secret *string  `json:"secret"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.URL != nil {
+		hook.URL = *input.URL
+	}
+	if input.Events != nil {
+		hook.Events = input.Events
+	}
+	if input.Secret != nil {
+		hook.Secret = *input.Secret
+	}
+	if input.Active != nil {
+		hook.Active = *input.Active
+	}
+
+	v := validator.New()
+
+	if app.validateWebhook(r.Context(), v, hook); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Webhooks.Update(r.Context(), hook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": hook}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Webhooks.Delete(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "webhook successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-id")
+	input.Filters.SortSafelist = []string{"id", "created_at", "attempts", "-id", "-created_at", "-attempts"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.models.Webhooks.GetAllDeliveries(r.Context(), hook.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.readWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.ParseInt(httprouter.ParamsFromContext(r.Context()).ByName("delivery_id"), 10, 64)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	delivery, err := app.models.Webhooks.GetDelivery(r.Context(), hook.ID, deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	job, err := app.enqueueJob(r.Context(), jobDeliverWebhook, deliverWebhookPayload{WebhookID: hook.ID, DeliveryID: delivery.ID}, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "delivery queued for redelivery", "job_id": job.ID}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateWebhook runs data.ValidateWebhook, requiring https outside
+// development, and rejects URLs whose host resolves to an address webhooks
+// may not be sent to. Deliveries check the address again when they connect.
+func (app *application) validateWebhook(ctx context.Context, v *validator.Validator, hook *data.Webhook) {
+	if data.ValidateWebhook(v, hook, app.config.env != "development"); !v.Valid() || app.config.webhooks.allowPrivate {
+		return
+	}
+
+	u, err := url.Parse(hook.URL)
+	if err != nil {
+		return
+	}
+
+	err = webhook.CheckHost(ctx, u.Hostname())
+	switch {
+	case errors.Is(err, webhook.ErrForbiddenAddress):
+		v.AddError("url", "must not point to a loopback, link-local or private address")
+	case err != nil:
+		v.AddError("url", "must have a host that resolves")
+	}
+}
+
+func (app *application) readWebhook(w http.ResponseWriter, r *http.Request) (*data.Webhook, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	hook, err := app.models.Webhooks.Get(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return hook, true
+}
@@ -0,0 +1,484 @@
+// Package configfile reads settings files written in TOML or YAML. Only the
+// parts of either format needed for settings are understood: tables or nested
+// mappings, strings, numbers, booleans and lists of those. Every setting comes
+// back as its dotted key, such as "db.dsn", with its values as strings.
+package configfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrUnsupportedFormat = errors.New("configfile: unsupported format, expected .toml, .yaml or .yml")
+
+var keyRX = regexp.MustCompile(`^[A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*$`)
+
+// Values maps dotted keys to their values. Lists have one value per item.
+type Values map[string][]string
+
+// Load reads the file at path, picking the format from its extension.
+func Load(path string) (Values, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values Values
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		values, err = ParseTOML(body)
+	case ".yaml", ".yml":
+		values, err = ParseYAML(body)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+func (v Values) set(line int, key string, values []string) error {
+	if _, exists := v[key]; exists {
+		return lineError(line, "duplicate key %q", key)
+	}
+
+	v[key] = values
+	return nil
+}
+
+// ParseTOML parses tables, key = value pairs and arrays, which may span
+// several lines.
+func ParseTOML(body []byte) (Values, error) {
+	values := Values{}
+	lines := splitLines(body)
+	table := ""
+
+	for i := 0; i < len(lines); i++ {
+		n := i + 1
+
+		line, err := stripComment(lines[i])
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "[["):
+			return nil, lineError(n, "arrays of tables are not supported")
+		case strings.HasPrefix(line, "["):
+			if !strings.HasSuffix(line, "]") {
+				return nil, lineError(n, "unterminated table header")
+			}
+
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if !keyRX.MatchString(table) {
+				return nil, lineError(n, "invalid table name %q", table)
+			}
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, lineError(n, "expected key = value")
+		}
+
+		key := strings.TrimSpace(line[:eq])
+		if !keyRX.MatchString(key) {
+			return nil, lineError(n, "invalid key %q", key)
+		}
+
+		if table != "" {
+			key = table + "." + key
+		}
+
+		raw := strings.TrimSpace(line[eq+1:])
+
+		// An array may be split over several lines, up to its closing bracket.
+		for strings.HasPrefix(raw, "[") && !balanced(raw) && i+1 < len(lines) {
+			i++
+
+			next, err := stripComment(lines[i])
+			if err != nil {
+				return nil, lineError(i+1, "%s", err)
+			}
+
+			raw += " " + strings.TrimSpace(next)
+		}
+
+		parsed, err := parseValue(raw, parseTOMLScalar)
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		err = values.set(n, key, parsed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// ParseYAML parses nested mappings indented with spaces, block lists of
+// scalars and flow lists such as [a, b].
+func ParseYAML(body []byte) (Values, error) {
+	type frame struct {
+		indent int
+		key    string
+		n      int
+		used   bool
+		list   bool
+	}
+
+	values := Values{}
+	stack := []*frame{}
+
+	// pop closes the mappings indented deeper than indent. A key without
+	// children or list items is an empty value.
+	pop := func(indent int, inclusive bool) error {
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.indent < indent || (top.indent == indent && !inclusive) {
+				break
+			}
+
+			stack = stack[:len(stack)-1]
+
+			if !top.used {
+				err := values.set(top.n, top.key, []string{""})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for i, raw := range splitLines(body) {
+		n := i + 1
+
+		line, err := stripComment(raw)
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		content := strings.TrimSpace(line)
+		if content == "" || (content == "---" && i == 0) {
+			continue
+		}
+
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if strings.HasPrefix(line[indent:], "\t") {
+			return nil, lineError(n, "tabs are not allowed for indentation")
+		}
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			err := pop(indent, false)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(stack) == 0 {
+				return nil, lineError(n, "list item outside of a key")
+			}
+
+			owner := stack[len(stack)-1]
+			if owner.used && !owner.list {
+				return nil, lineError(n, "list item in a mapping")
+			}
+
+			item := strings.TrimSpace(strings.TrimPrefix(content, "-"))
+			if strings.HasPrefix(item, "[") || strings.HasPrefix(item, "{") || (strings.Contains(item, ": ") && !isQuoted(item)) {
+				return nil, lineError(n, "only lists of scalars are supported")
+			}
+
+			value, err := parseYAMLScalar(item)
+			if err != nil {
+				return nil, lineError(n, "%s", err)
+			}
+
+			owner.used, owner.list = true, true
+			values[owner.key] = append(values[owner.key], value)
+			continue
+		}
+
+		colon := yamlColon(content)
+		if colon < 0 {
+			return nil, lineError(n, "expected key: value")
+		}
+
+		err = pop(indent, true)
+		if err != nil {
+			return nil, err
+		}
+
+		key := strings.TrimSpace(content[:colon])
+		if !keyRX.MatchString(key) {
+			return nil, lineError(n, "invalid key %q", key)
+		}
+
+		if len(stack) > 0 {
+			parent := stack[len(stack)-1]
+			if parent.list {
+				return nil, lineError(n, "key in a list")
+			}
+
+			parent.used = true
+			key = parent.key + "." + key
+		}
+
+		rest := strings.TrimSpace(content[colon+1:])
+
+		if rest == "" {
+			stack = append(stack, &frame{indent: indent, key: key, n: n})
+			continue
+		}
+
+		parsed, err := parseValue(rest, parseYAMLScalar)
+		if err != nil {
+			return nil, lineError(n, "%s", err)
+		}
+
+		err = values.set(n, key, parsed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := pop(0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// parseValue parses a scalar or a one-level [a, b] list of scalars.
+func parseValue(raw string, scalar func(string) (string, error)) ([]string, error) {
+	if !strings.HasPrefix(raw, "[") {
+		value, err := scalar(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []string{value}, nil
+	}
+
+	if !strings.HasSuffix(raw, "]") || !balanced(raw) {
+		return nil, errors.New("unterminated list")
+	}
+
+	items, err := splitItems(raw[1 : len(raw)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+
+	for _, item := range items {
+		if strings.HasPrefix(item, "[") || strings.HasPrefix(item, "{") {
+			return nil, errors.New("only lists of scalars are supported")
+		}
+
+		value, err := scalar(item)
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func parseTOMLScalar(raw string) (string, error) {
+	switch {
+	case raw == "":
+		return "", errors.New("missing value")
+	case strings.HasPrefix(raw, `"""`) || strings.HasPrefix(raw, "'''"):
+		return "", errors.New("multi-line strings are not supported")
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") || strings.Contains(raw[1:len(raw)-1], "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case strings.HasPrefix(raw, "{"):
+		return "", errors.New("inline tables are not supported")
+	case strings.ContainsAny(raw, " \t"):
+		return "", fmt.Errorf("invalid value %q, strings must be quoted", raw)
+	default:
+		return raw, nil
+	}
+}
+
+func parseYAMLScalar(raw string) (string, error) {
+	switch {
+	case raw == "~" || raw == "null":
+		return "", nil
+	case strings.HasPrefix(raw, `"`):
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return value, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return strings.ReplaceAll(raw[1:len(raw)-1], "''", "'"), nil
+	case strings.HasPrefix(raw, "{"):
+		return "", errors.New("flow mappings are not supported")
+	case strings.HasPrefix(raw, "|") || strings.HasPrefix(raw, ">"):
+		return "", errors.New("block scalars are not supported")
+	case strings.HasPrefix(raw, "&") || strings.HasPrefix(raw, "*") || strings.HasPrefix(raw, "!"):
+		return "", errors.New("anchors, aliases and tags are not supported")
+	default:
+		return raw, nil
+	}
+}
+
+// yamlColon returns the index of the colon that ends the key of a mapping
+// entry, which has to be followed by a space or the end of the line.
+func yamlColon(content string) int {
+	for i := 0; i < len(content); i++ {
+		if content[i] == ':' && (i+1 == len(content) || content[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+// stripComment removes a # comment that isn't inside a quoted string. In
+// both formats a comment has to start the line or follow whitespace.
+func stripComment(line string) (string, error) {
+	var quote byte
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && opensQuote(line, i):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i], nil
+		}
+	}
+
+	if quote != 0 {
+		return "", errors.New("unterminated string")
+	}
+
+	return line, nil
+}
+
+// splitItems splits the inside of a flow list on the commas outside of
+// quoted strings. A trailing comma is allowed.
+func splitItems(s string) ([]string, error) {
+	items := []string{}
+	start := 0
+	var quote byte
+
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) {
+			c := s[i]
+
+			switch {
+			case quote == '"' && c == '\\':
+				i++
+				continue
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+				continue
+			case (c == '"' || c == '\'') && opensQuote(s, i):
+				quote = c
+				continue
+			case c != ',':
+				continue
+			}
+		}
+
+		item := strings.TrimSpace(s[start:i])
+		start = i + 1
+
+		if item == "" {
+			if i == len(s) {
+				break
+			}
+			return nil, errors.New("empty list item")
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// balanced reports whether every [ in s outside of quoted strings is closed.
+func balanced(s string) bool {
+	depth := 0
+	var quote byte
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && opensQuote(s, i):
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+
+	return depth == 0
+}
+
+// opensQuote reports whether the quote at s[i] starts a string rather than
+// being part of a plain value such as it's.
+func opensQuote(s string, i int) bool {
+	return i == 0 || strings.IndexByte(" \t[,:=", s[i-1]) >= 0
+}
+
+func isQuoted(s string) bool {
+	return strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'")
+}
+
+func splitLines(body []byte) []string {
+	return strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+}
+
+func lineError(line int, format string, args ...any) error {
+	return fmt.Errorf("configfile: line %d: %s", line, fmt.Sprintf(format, args...))
+}
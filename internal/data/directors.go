@@ -2,37 +2,45 @@ package data
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"github.com/lib/pq"
 	"time"
 )
 
 type Director struct {
-	ID      int64    `json:"id"`
-	Name    string   `json:"name"`
-	Surname string   `json:"surname"`
-	Awards  []string `json:"awards,omitempty"`
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Surname   string   `json:"surname"`
+	Awards    []string `json:"awards,omitempty"`
+	CreatedBy int64    `json:"-"`
 }
 
 type DirectorModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Timeout time.Duration
 }
 
-func (d DirectorModel) Insert(director *Director) error {
-	query := `INSERT INTO directors (name, surname, awards)
-			  VALUES ($1, $2, $3)
+func (d DirectorModel) Insert(ctx context.Context, director *Director) error {
+	query := `INSERT INTO directors (name, surname, awards, created_by)
+			  VALUES ($1, $2, $3, NULLIF($4, 0))
 			  RETURNING id`
 
-	args := []any{director.Name, director.Surname, pq.Array(director.Awards)}
+	args := []any{director.Name, director.Surname, pq.Array(director.Awards), director.CreatedBy}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	return withTx(ctx, d.DB, func(tx DBTX) error {
+		ctx, cancel := withQueryTimeout(ctx, d.Timeout)
+		defer cancel()
 
-	return d.DB.QueryRowContext(ctx, query, args...).Scan(&director.ID)
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&director.ID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		return insertEvent(ctx, tx, EventDirectorCreated, EventAggregateDirector, director.ID, director)
+	})
 }
 
-func (d DirectorModel) GetAll(name string, filters Filters) ([]*Director, Metadata, error) {
+func (d DirectorModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Director, Metadata, error) {
 	query := fmt.Sprintf(`
 		SELECT count(*) OVER(), id, name, surname, awards
 		FROM directors
@@ -40,14 +48,14 @@ func (d DirectorModel) GetAll(name string, filters Filters) ([]*Director, Metada
 		ORDER BY %s %s, id ASC
 		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, d.Timeout)
 	defer cancel()
 
 	args := []any{name, filters.limit(), filters.offset()}
 
 	rows, err := d.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 
 	defer rows.Close()
@@ -67,17 +75,60 @@ func (d DirectorModel) GetAll(name string, filters Filters) ([]*Director, Metada
 		)
 
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, queryError(ctx, err)
 		}
 
 		directors = append(directors, &director)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, queryError(ctx, err)
 	}
 
 	metadata := CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
 
 	return directors, metadata, nil
 }
+
+func (d DirectorModel) GetAllCreatedBy(ctx context.Context, userID int64) ([]*Director, error) {
+	query := `
+		SELECT id, name, surname, awards
+		FROM directors
+		WHERE created_by = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := withQueryTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	rows, err := d.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	directors := []*Director{}
+
+	for rows.Next() {
+		var director Director
+
+		err := rows.Scan(
+			&director.ID,
+			&director.Name,
+			&director.Surname,
+			pq.Array(&director.Awards),
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		director.CreatedBy = userID
+		directors = append(directors, &director)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return directors, nil
+}
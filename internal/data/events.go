@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	EventMovieCreated          = "movie.created"
+	EventMovieUpdated          = "movie.updated"
+	EventMovieDeleted          = "movie.deleted"
+	EventDirectorCreated       = "director.created"
+	EventTrailerCreated        = "trailer.created"
+	EventUserCreated           = "user.created"
+	EventUserUpdated           = "user.updated"
+	EventUserActivated         = "user.activated"
+	EventUserDeletionScheduled = "user.deletion_scheduled"
+	EventUserDeletionCancelled = "user.deletion_cancelled"
+	EventUserDeleted           = "user.deleted"
+	EventAggregateMovie        = "movie"
+	EventAggregateDirector     = "director"
+	EventAggregateTrailer      = "trailer"
+	EventAggregateUser         = "user"
+)
+
+type Event struct {
+	ID            int64           `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int64           `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+type EventModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// GetAfter returns up to limit events with an ID greater than after, in ID
+// order. IDs are handed out when a row is inserted rather than when its
+// transaction commits, so an event may become visible after one with a
+// higher ID. To keep readers that resume from the last ID they saw from
+// skipping it, events are only returned once every transaction that was
+// running when they were written has finished.
+func (m EventModel) GetAfter(ctx context.Context, after int64, limit int) ([]*Event, error) {
+	return m.getAfter(ctx, "", after, limit)
+}
+
+// GetAfterForAggregate is like GetAfter but only returns events about one
+// kind of aggregate, such as "movie".
+func (m EventModel) GetAfterForAggregate(ctx context.Context, aggregateType string, after int64, limit int) ([]*Event, error) {
+	return m.getAfter(ctx, aggregateType, after, limit)
+}
+
+func (m EventModel) getAfter(ctx context.Context, aggregateType string, after int64, limit int) ([]*Event, error) {
+	query := `
+		SELECT id, type, aggregate_type, aggregate_id, payload, created_at
+		FROM events
+		WHERE id > $1
+		AND (aggregate_type = $2 OR $2 = '')
+		AND txid < pg_snapshot_xmin(pg_current_snapshot())
+		ORDER BY id ASC
+		LIMIT $3`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, after, aggregateType, limit)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var (
+			event   Event
+			payload []byte
+		)
+
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.AggregateType,
+			&event.AggregateID,
+			&payload,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		event.Payload = payload
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return events, nil
+}
+
+// LatestID returns the ID of the most recent event, or 0 if there are none.
+func (m EventModel) LatestID(ctx context.Context) (int64, error) {
+	query := `SELECT COALESCE(max(id), 0) FROM events`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var id int64
+
+	err := m.DB.QueryRowContext(ctx, query).Scan(&id)
+	return id, queryError(ctx, err)
+}
+
+// DeleteBefore deletes the events created before the given time.
+func (m EventModel) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM events WHERE created_at < $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
+}
+
+func insertEvent(ctx context.Context, db DBTX, eventType, aggregateType string, aggregateID int64, payload any) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO events (type, aggregate_type, aggregate_id, payload)
+		VALUES ($1, $2, $3, $4)`
+
+	_, err = db.ExecContext(ctx, query, eventType, aggregateType, aggregateID, js)
+	return err
+}
+
+// withTx runs fn in a transaction, reusing the one db already belongs to
+// when the model was handed out by Models.Transaction.
+func withTx(ctx context.Context, db DBTX, fn func(tx DBTX) error) error {
+	conn, ok := db.(*sql.DB)
+	if !ok {
+		return fn(db)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return queryError(ctx, tx.Commit())
+}
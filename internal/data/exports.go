@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type ExportModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+func (m ExportModel) New(ctx context.Context, userID int64, ttl time.Duration, archive []byte) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeExport)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO data_exports (hash, user_id, archive, expiry)
+		VALUES ($1, $2, $3, $4)`
+
+	args := []any{token.Hash, token.UserID, archive, token.Expiry}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return token, queryError(ctx, err)
+}
+
+func (m ExportModel) GetArchive(ctx context.Context, tokenPlaintext string) ([]byte, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+		SELECT archive
+		FROM data_exports
+		WHERE hash = $1 AND expiry > $2`
+
+	var archive []byte
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], time.Now()).Scan(&archive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return archive, nil
+}
+
+func (m ExportModel) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	query := `
+		DELETE FROM data_exports
+		WHERE expiry <= $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
+}
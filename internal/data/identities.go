@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrDuplicateIdentity = errors.New("duplicate user identity")
+
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"-"`
+	UserID    int64     `json:"-"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OAuthState struct {
+	State        string
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	Expiry       time.Time
+}
+
+type UserIdentityModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+func (m UserIdentityModel) Insert(ctx context.Context, identity *UserIdentity) error {
+	query := `
+		INSERT INTO user_identities (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING created_at`
+
+	args := []any{identity.Provider, identity.Subject, identity.UserID, identity.Email}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&identity.CreatedAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_pkey"`:
+			return ErrDuplicateIdentity
+		default:
+			return queryError(ctx, err)
+		}
+	}
+
+	return nil
+}
+
+func (m UserIdentityModel) GetUser(ctx context.Context, provider, subject string) (*User, error) {
+	query := `
+		SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.role, users.language, users.version
+		FROM users
+		INNER JOIN user_identities
+		ON users.id = user_identities.user_id
+		WHERE user_identities.provider = $1
+		AND user_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Role,
+		&user.Language,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &user, nil
+}
+
+func (m UserIdentityModel) GetAllForUser(ctx context.Context, userID int64) ([]*UserIdentity, error) {
+	query := `
+		SELECT provider, subject, user_id, email, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at ASC`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	identities := []*UserIdentity{}
+
+	for rows.Next() {
+		var identity UserIdentity
+
+		err := rows.Scan(
+			&identity.Provider,
+			&identity.Subject,
+			&identity.UserID,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		identities = append(identities, &identity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return identities, nil
+}
+
+func (m UserIdentityModel) InsertState(ctx context.Context, state *OAuthState) error {
+	query := `
+		INSERT INTO oauth_states (state, provider, code_verifier, nonce, expiry)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	args := []any{state.State, state.Provider, state.CodeVerifier, state.Nonce, state.Expiry}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return queryError(ctx, err)
+}
+
+func (m UserIdentityModel) ConsumeState(ctx context.Context, provider, state string) (*OAuthState, error) {
+	query := `
+		DELETE FROM oauth_states
+		WHERE state = $1 AND provider = $2 AND expiry > $3
+		RETURNING state, provider, code_verifier, nonce, expiry`
+
+	var s OAuthState
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, state, provider, time.Now()).Scan(
+		&s.State,
+		&s.Provider,
+		&s.CodeVerifier,
+		&s.Nonce,
+		&s.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return &s, nil
+}
+
+func (m UserIdentityModel) DeleteExpiredStates(ctx context.Context, now time.Time) (int64, error) {
+	query := `
+		DELETE FROM oauth_states
+		WHERE expiry <= $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
+}
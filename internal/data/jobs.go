@@ -0,0 +1,339 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedUntil *time.Time      `json:"locked_until,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+type JobModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+// Insert queues a job. A job whose unique key is already taken is silently
+// skipped and left with a zero ID, which is how recurring jobs are kept from
+// being queued once per running instance.
+func (m JobModel) Insert(ctx context.Context, job *Job) error {
+	if len(job.Payload) == 0 {
+		job.Payload = json.RawMessage("{}")
+	}
+
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO jobs (kind, payload, max_attempts, unique_key, run_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		ON CONFLICT (unique_key) DO NOTHING
+		RETURNING id, status, attempts, created_at`
+
+	args := []any{job.Kind, []byte(job.Payload), job.MaxAttempts, job.UniqueKey, job.RunAt}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&job.ID, &job.Status, &job.Attempts, &job.CreatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return queryError(ctx, err)
+	}
+
+	return nil
+}
+
+// Claim marks up to limit due jobs as running for the lease duration. Jobs
+// whose lease ran out while running belonged to a worker that died, and are
+// picked up again if they have attempts left, or failed otherwise.
+func (m JobModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Job, error) {
+	query := `
+		WITH exhausted AS (
+			UPDATE jobs
+			SET status = 'failed', last_error = 'lease expired on the last attempt', locked_until = NULL, finished_at = $2
+			WHERE status = 'running' AND locked_until <= $2 AND attempts >= max_attempts
+		)
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_until = $1
+		WHERE id IN (
+			SELECT id
+			FROM jobs
+			WHERE (status = 'pending' AND run_at <= $2)
+			OR (status = 'running' AND locked_until <= $2 AND attempts < max_attempts)
+			ORDER BY run_at ASC, id ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, status, attempts, max_attempts, last_error, COALESCE(unique_key, ''), run_at, locked_until, created_at, finished_at`
+
+	now := time.Now()
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	jobs := []*Job{}
+
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return jobs, nil
+}
+
+func (m JobModel) Complete(ctx context.Context, id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = 'succeeded', last_error = '', locked_until = NULL, finished_at = $1
+		WHERE id = $2 AND status = 'running'`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	return queryError(ctx, err)
+}
+
+// Fail records a failed attempt, either scheduling the job to run again at
+// runAt or, once it has no attempts left, marking it as failed for good.
+func (m JobModel) Fail(ctx context.Context, id int64, jobErr error, runAt time.Time, final bool) error {
+	status := JobStatusPending
+	var finishedAt *time.Time
+
+	if final {
+		now := time.Now()
+		status = JobStatusFailed
+		finishedAt = &now
+	}
+
+	query := `
+		UPDATE jobs
+		SET status = $1, last_error = $2, run_at = $3, locked_until = NULL, finished_at = $4
+		WHERE id = $5 AND status = 'running'`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, jobErr.Error(), runAt, finishedAt, id)
+	return queryError(ctx, err)
+}
+
+func (m JobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, kind, payload, status, attempts, max_attempts, last_error, COALESCE(unique_key, ''), run_at, locked_until, created_at, finished_at
+		FROM jobs
+		WHERE id = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	job, err := scanJob(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return job, nil
+}
+
+func (m JobModel) GetAll(ctx context.Context, status, kind string, filters Filters) ([]*Job, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, kind, payload, status, attempts, max_attempts, last_error, COALESCE(unique_key, ''), run_at, locked_until, created_at, finished_at
+		FROM jobs
+		WHERE (status = $1 OR $1 = '')
+		AND (kind = $2 OR $2 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, kind, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+
+	for rows.Next() {
+		var (
+			job     Job
+			payload []byte
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&job.ID,
+			&job.Kind,
+			&payload,
+			&job.Status,
+			&job.Attempts,
+			&job.MaxAttempts,
+			&job.LastError,
+			&job.UniqueKey,
+			&job.RunAt,
+			&job.LockedUntil,
+			&job.CreatedAt,
+			&job.FinishedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		job.Payload = payload
+
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return jobs, metadata, nil
+}
+
+// Retry puts a failed or cancelled job back in the queue with a fresh set of
+// attempts.
+func (m JobModel) Retry(ctx context.Context, id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = 'pending', attempts = 0, last_error = '', run_at = $1, finished_at = NULL
+		WHERE id = $2 AND status IN ('failed', 'cancelled')`
+
+	return m.transition(ctx, query, time.Now(), id)
+}
+
+// Cancel stops a pending job from running. Running jobs can't be cancelled.
+func (m JobModel) Cancel(ctx context.Context, id int64) error {
+	query := `
+		UPDATE jobs
+		SET status = 'cancelled', finished_at = $1
+		WHERE id = $2 AND status = 'pending'`
+
+	return m.transition(ctx, query, time.Now(), id)
+}
+
+// DeleteFinished deletes the succeeded and cancelled jobs that finished
+// before the given time. Failed jobs are kept for an admin to retry.
+func (m JobModel) DeleteFinished(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM jobs
+		WHERE status IN ('succeeded', 'cancelled') AND finished_at < $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
+}
+
+func (m JobModel) transition(ctx context.Context, query string, now time.Time, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func scanJob(row scanner) (*Job, error) {
+	var (
+		job     Job
+		payload []byte
+	)
+
+	err := row.Scan(
+		&job.ID,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.UniqueKey,
+		&job.RunAt,
+		&job.LockedUntil,
+		&job.CreatedAt,
+		&job.FinishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Payload = payload
+
+	return &job, nil
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type LoginAttemptModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+func (m LoginAttemptModel) Insert(ctx context.Context, email, ip string) error {
+	query := `
+		INSERT INTO login_attempts (email, ip)
+		VALUES ($1, $2)`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, ip)
+	return queryError(ctx, err)
+}
+
+// Record adds an attempt for email and returns the number made since, this
+// one included. Attempts for an email are recorded one at a time, so that
+// concurrent ones each count those before them; the lock is held until the
+// transaction ends, so Record is meant to be called in one.
+func (m LoginAttemptModel) Record(ctx context.Context, email, ip string, since time.Time) (int, error) {
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext(lower($1)))`, email)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	_, err = m.DB.ExecContext(ctx, `INSERT INTO login_attempts (email, ip) VALUES ($1, $2)`, email, ip)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	query := `
+		SELECT count(*)
+		FROM login_attempts
+		WHERE email = $1 AND created_at > $2`
+
+	var count int
+
+	err = m.DB.QueryRowContext(ctx, query, email, since).Scan(&count)
+	return count, queryError(ctx, err)
+}
+
+func (m LoginAttemptModel) CountForEmail(ctx context.Context, email string, since time.Time) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM login_attempts
+		WHERE email = $1 AND created_at > $2`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, email, since).Scan(&count)
+	return count, queryError(ctx, err)
+}
+
+func (m LoginAttemptModel) CountForIP(ctx context.Context, ip string, since time.Time) (int, error) {
+	query := `
+		SELECT count(*)
+		FROM login_attempts
+		WHERE ip = $1 AND created_at > $2`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var count int
+
+	err := m.DB.QueryRowContext(ctx, query, ip, since).Scan(&count)
+	return count, queryError(ctx, err)
+}
+
+func (m LoginAttemptModel) DeleteAllForEmail(ctx context.Context, email string) error {
+	query := `
+		DELETE FROM login_attempts
+		WHERE email = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	return queryError(ctx, err)
+}
+
+func (m LoginAttemptModel) Lock(ctx context.Context, email string, until time.Time) error {
+	query := `
+		INSERT INTO account_lockouts (email, locked_until)
+		VALUES ($1, $2)
+		ON CONFLICT (email) DO UPDATE SET locked_until = EXCLUDED.locked_until`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email, until)
+	return queryError(ctx, err)
+}
+
+func (m LoginAttemptModel) GetLock(ctx context.Context, email string) (time.Time, error) {
+	query := `
+		SELECT locked_until
+		FROM account_lockouts
+		WHERE email = $1 AND locked_until > $2`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var lockedUntil time.Time
+
+	err := m.DB.QueryRowContext(ctx, query, email, time.Now()).Scan(&lockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrRecordNotFound
+		default:
+			return time.Time{}, queryError(ctx, err)
+		}
+	}
+
+	return lockedUntil, nil
+}
+
+func (m LoginAttemptModel) Unlock(ctx context.Context, email string) error {
+	query := `
+		DELETE FROM account_lockouts
+		WHERE email = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, email)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	return m.DeleteAllForEmail(ctx, email)
+}
+
+// DeleteStale removes login attempts made before since along with lockouts
+// that have already run out.
+func (m LoginAttemptModel) DeleteStale(ctx context.Context, since, now time.Time) (int64, error) {
+	query := `
+		WITH attempts AS (
+			DELETE FROM login_attempts
+			WHERE created_at <= $1
+			RETURNING 1
+		), lockouts AS (
+			DELETE FROM account_lockouts
+			WHERE locked_until <= $2
+		)
+		SELECT count(*) FROM attempts`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var count int64
+
+	err := m.DB.QueryRowContext(ctx, query, since, now).Scan(&count)
+	return count, queryError(ctx, err)
+}
@@ -0,0 +1,414 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Primary key violations that Postgres reports as plain errors.
+var (
+	errDuplicateOAuthState = errors.New("duplicate oauth state")
+)
+
+// memoryStore holds the tables behind the in-memory models. Every call takes
+// the store's lock for its whole duration, so each one is atomic the way a
+// single statement or withTx block is in Postgres. A store handed out by
+// transaction is already holding the lock and doesn't take it again.
+type memoryStore struct {
+	mu     *sync.Mutex
+	locked bool
+	seq    map[string]int64
+	t      *memoryTables
+}
+
+// memoryTables maps each table's key to a row. Rows are stored by value and
+// replaced rather than modified in place, so copying the maps is enough to
+// snapshot the tables for a transaction.
+type memoryTables struct {
+	movies           map[int64]Movie
+	trailers         map[int64]Trailer
+	directors        map[int64]Director
+	users            map[int64]User
+	tokens           map[string]Token
+	loginAttempts    []memoryLoginAttempt
+	lockouts         map[string]time.Time
+	apiKeys          map[int64]APIKey
+	revokedTokens    map[string]time.Time
+	issuedTokens     map[string]memoryIssuedToken
+	identities       []UserIdentity
+	oauthStates      map[string]OAuthState
+	accountDeletions map[int64]time.Time
+	exports          map[string]memoryExport
+	outbox           map[int64]memoryOutboxEmail
+	jobs             map[int64]Job
+	webhooks         map[int64]Webhook
+	deliveries       map[int64]WebhookDelivery
+	events           []Event
+}
+
+type memoryLoginAttempt struct {
+	email     string
+	ip        string
+	createdAt time.Time
+}
+
+type memoryIssuedToken struct {
+	userID int64
+	expiry time.Time
+}
+
+type memoryExport struct {
+	userID  int64
+	archive []byte
+	expiry  time.Time
+}
+
+type memoryOutboxEmail struct {
+	email OutboxEmail
+	data  []byte
+}
+
+// NewMemoryModels returns models that keep their data in memory instead of
+// Postgres. They are safe for concurrent use and behave like the Postgres
+// models, which makes them a fast stand-in for a database in tests.
+func NewMemoryModels() Models {
+	store := &memoryStore{
+		mu:  &sync.Mutex{},
+		seq: make(map[string]int64),
+		t: &memoryTables{
+			movies:           make(map[int64]Movie),
+			trailers:         make(map[int64]Trailer),
+			directors:        make(map[int64]Director),
+			users:            make(map[int64]User),
+			tokens:           make(map[string]Token),
+			lockouts:         make(map[string]time.Time),
+			apiKeys:          make(map[int64]APIKey),
+			revokedTokens:    make(map[string]time.Time),
+			issuedTokens:     make(map[string]memoryIssuedToken),
+			oauthStates:      make(map[string]OAuthState),
+			accountDeletions: make(map[int64]time.Time),
+			exports:          make(map[string]memoryExport),
+			outbox:           make(map[int64]memoryOutboxEmail),
+			jobs:             make(map[int64]Job),
+			webhooks:         make(map[int64]Webhook),
+			deliveries:       make(map[int64]WebhookDelivery),
+		},
+	}
+
+	return newMemoryModels(store)
+}
+
+func newMemoryModels(s *memoryStore) Models {
+	return Models{
+		Movies:        memoryMovieModel{s},
+		Trailers:      memoryTrailerModel{s},
+		Directors:     memoryDirectorModel{s},
+		Users:         memoryUserModel{s},
+		Tokens:        memoryTokenModel{s},
+		LoginAttempts: memoryLoginAttemptModel{s},
+		APIKeys:       memoryAPIKeyModel{s},
+		RevokedTokens: memoryRevokedTokenModel{s},
+		Identities:    memoryUserIdentityModel{s},
+		Exports:       memoryExportModel{s},
+		Outbox:        memoryOutboxModel{s},
+		Jobs:          memoryJobModel{s},
+		Webhooks:      memoryWebhookModel{s},
+		Events:        memoryEventModel{s},
+		memory:        s,
+	}
+}
+
+// begin locks the store for one call, failing the way a query would if ctx
+// is already done.
+func (s *memoryStore) begin(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	if s.locked {
+		return func() {}, nil
+	}
+
+	s.mu.Lock()
+	return s.mu.Unlock, nil
+}
+
+// transaction runs fn with models that see the store under a single lock,
+// putting the tables back as they were if fn fails. As with Postgres
+// sequences, IDs handed out inside a rolled back transaction stay used.
+func (s *memoryStore) transaction(ctx context.Context, fn func(tx Models) error) error {
+	unlock, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshot := s.t.clone()
+
+	err = fn(newMemoryModels(&memoryStore{mu: s.mu, locked: true, seq: s.seq, t: s.t}))
+	if err == nil && ctx.Err() != nil {
+		err = queryError(ctx, ctx.Err())
+	}
+
+	if err != nil {
+		*s.t = snapshot
+		return err
+	}
+
+	return nil
+}
+
+func (s *memoryStore) nextID(table string) int64 {
+	s.seq[table]++
+	return s.seq[table]
+}
+
+func (s *memoryStore) insertEvent(eventType, aggregateType string, aggregateID int64, payload any) error {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	s.t.events = append(s.t.events, Event{
+		ID:            s.nextID("events"),
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Payload:       js,
+		CreatedAt:     time.Now(),
+	})
+
+	return nil
+}
+
+func (t *memoryTables) clone() memoryTables {
+	c := memoryTables{
+		movies:           make(map[int64]Movie, len(t.movies)),
+		trailers:         make(map[int64]Trailer, len(t.trailers)),
+		directors:        make(map[int64]Director, len(t.directors)),
+		users:            make(map[int64]User, len(t.users)),
+		tokens:           make(map[string]Token, len(t.tokens)),
+		loginAttempts:    append([]memoryLoginAttempt(nil), t.loginAttempts...),
+		lockouts:         make(map[string]time.Time, len(t.lockouts)),
+		apiKeys:          make(map[int64]APIKey, len(t.apiKeys)),
+		revokedTokens:    make(map[string]time.Time, len(t.revokedTokens)),
+		issuedTokens:     make(map[string]memoryIssuedToken, len(t.issuedTokens)),
+		identities:       append([]UserIdentity(nil), t.identities...),
+		oauthStates:      make(map[string]OAuthState, len(t.oauthStates)),
+		accountDeletions: make(map[int64]time.Time, len(t.accountDeletions)),
+		exports:          make(map[string]memoryExport, len(t.exports)),
+		outbox:           make(map[int64]memoryOutboxEmail, len(t.outbox)),
+		jobs:             make(map[int64]Job, len(t.jobs)),
+		webhooks:         make(map[int64]Webhook, len(t.webhooks)),
+		deliveries:       make(map[int64]WebhookDelivery, len(t.deliveries)),
+		events:           append([]Event(nil), t.events...),
+	}
+
+	for k, v := range t.movies {
+		c.movies[k] = v
+	}
+	for k, v := range t.trailers {
+		c.trailers[k] = v
+	}
+	for k, v := range t.directors {
+		c.directors[k] = v
+	}
+	for k, v := range t.users {
+		c.users[k] = v
+	}
+	for k, v := range t.tokens {
+		c.tokens[k] = v
+	}
+	for k, v := range t.lockouts {
+		c.lockouts[k] = v
+	}
+	for k, v := range t.apiKeys {
+		c.apiKeys[k] = v
+	}
+	for k, v := range t.revokedTokens {
+		c.revokedTokens[k] = v
+	}
+	for k, v := range t.issuedTokens {
+		c.issuedTokens[k] = v
+	}
+	for k, v := range t.oauthStates {
+		c.oauthStates[k] = v
+	}
+	for k, v := range t.accountDeletions {
+		c.accountDeletions[k] = v
+	}
+	for k, v := range t.exports {
+		c.exports[k] = v
+	}
+	for k, v := range t.outbox {
+		c.outbox[k] = v
+	}
+	for k, v := range t.jobs {
+		c.jobs[k] = v
+	}
+	for k, v := range t.webhooks {
+		c.webhooks[k] = v
+	}
+	for k, v := range t.deliveries {
+		c.deliveries[k] = v
+	}
+
+	return c
+}
+
+// paginate orders records by the filters' sort column and then by ID, as
+// the Postgres models do, and returns the requested page. compare reports
+// how a and b order on the named column, before the sort direction is
+// applied.
+func paginate[T any](records []T, filters Filters, id func(T) int64, compare func(column string, a, b T) int) ([]T, Metadata) {
+	// sortColumn panics on a sort value missing from the safelist, and the
+	// in-memory models should fail the same way.
+	filters.sortColumn()
+
+	column := strings.TrimPrefix(filters.Sort, "-")
+	desc := filters.sortDirection() == "DESC"
+
+	sort.Slice(records, func(i, j int) bool {
+		c := compare(column, records[i], records[j])
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return id(records[i]) < id(records[j])
+	})
+
+	total := len(records)
+	start := filters.offset()
+	end := start + filters.limit()
+
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	page := records[start:end]
+	if len(page) == 0 {
+		// Postgres reads the total from the rows of the page, so a page past
+		// the end has no metadata.
+		total = 0
+	}
+
+	return page, CalculateMetadata(total, filters.Page, filters.PageSize)
+}
+
+func sortByID[T any](records []T, id func(T) int64) {
+	sort.Slice(records, func(i, j int) bool { return id(records[i]) < id(records[j]) })
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareStringSlices(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt64(int64(len(a)), int64(len(b)))
+}
+
+// matchesWords reports whether text contains every word of query, which is
+// what matching to_tsvector('simple', text) against
+// plainto_tsquery('simple', query) amounts to. An empty query matches
+// everything.
+func matchesWords(text, query string) bool {
+	if query == "" {
+		return true
+	}
+
+	queryWords := words(query)
+	if len(queryWords) == 0 {
+		return false
+	}
+
+	textWords := make(map[string]bool)
+	for _, word := range words(text) {
+		textWords[word] = true
+	}
+
+	for _, word := range queryWords {
+		if !textWords[word] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func containsAll(values, want []string) bool {
+	for _, w := range want {
+		if !containsString(values, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string{}, s...)
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
@@ -0,0 +1,286 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+type memoryMovieModel struct {
+	s *memoryStore
+}
+
+func (m memoryMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row := *movie
+	row.ID = m.s.nextID("movies")
+	row.CreatedAt = time.Now()
+	row.Genres = copyStrings(movie.Genres)
+	row.Version = 1
+
+	m.s.t.movies[row.ID] = row
+
+	movie.ID, movie.CreatedAt, movie.Version = row.ID, row.CreatedAt, row.Version
+
+	return m.s.insertEvent(EventMovieCreated, EventAggregateMovie, movie.ID, movie)
+}
+
+func (m memoryMovieModel) Get(ctx context.Context, id int64) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.movies[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.read(row), nil
+}
+
+func (m memoryMovieModel) Update(ctx context.Context, movie *Movie) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.movies[movie.ID]
+	if !ok || row.Version != movie.Version {
+		return ErrEditConflict
+	}
+
+	row.Title = movie.Title
+	row.Year = movie.Year
+	row.Runtime = movie.Runtime
+	row.Genres = copyStrings(movie.Genres)
+	row.Version++
+
+	m.s.t.movies[row.ID] = row
+
+	movie.Version = row.Version
+
+	return m.s.insertEvent(EventMovieUpdated, EventAggregateMovie, movie.ID, movie)
+}
+
+func (m memoryMovieModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.movies[id]
+	if !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.t.movies, id)
+
+	return m.s.insertEvent(EventMovieDeleted, EventAggregateMovie, id, *m.read(row))
+}
+
+func (m memoryMovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer unlock()
+
+	movies := []*Movie{}
+
+	for _, row := range m.s.t.movies {
+		if matchesWords(row.Title, title) && containsAll(row.Genres, genres) {
+			movies = append(movies, m.read(row))
+		}
+	}
+
+	movies, metadata := paginate(movies, filters, func(movie *Movie) int64 { return movie.ID }, compareMovies)
+
+	return movies, metadata, nil
+}
+
+func (m memoryMovieModel) GetAllCreatedBy(ctx context.Context, userID int64) ([]*Movie, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	movies := []*Movie{}
+
+	for _, row := range m.s.t.movies {
+		if row.CreatedBy != 0 && row.CreatedBy == userID {
+			movie := m.read(row)
+			movie.CreatedBy = userID
+			movies = append(movies, movie)
+		}
+	}
+
+	sortByID(movies, func(movie *Movie) int64 { return movie.ID })
+
+	return movies, nil
+}
+
+// read returns a copy of row holding the columns the Postgres model selects,
+// which leaves out created_by.
+func (m memoryMovieModel) read(row Movie) *Movie {
+	row.Genres = copyStrings(row.Genres)
+	row.CreatedBy = 0
+	return &row
+}
+
+func compareMovies(column string, a, b *Movie) int {
+	switch column {
+	case "title":
+		return strings.Compare(a.Title, b.Title)
+	case "year":
+		return compareInt64(int64(a.Year), int64(b.Year))
+	case "runtime":
+		return compareInt64(int64(a.Runtime), int64(b.Runtime))
+	case "genres":
+		return compareStringSlices(a.Genres, b.Genres)
+	}
+	return compareInt64(a.ID, b.ID)
+}
+
+type memoryTrailerModel struct {
+	s *memoryStore
+}
+
+func (t memoryTrailerModel) Insert(ctx context.Context, trailer *Trailer) error {
+	unlock, err := t.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	trailer.ID = t.s.nextID("trailers")
+	t.s.t.trailers[trailer.ID] = *trailer
+
+	return t.s.insertEvent(EventTrailerCreated, EventAggregateTrailer, trailer.ID, trailer)
+}
+
+func (t memoryTrailerModel) GetAllCreatedBy(ctx context.Context, userID int64) ([]*Trailer, error) {
+	unlock, err := t.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	trailers := []*Trailer{}
+
+	for _, row := range t.s.t.trailers {
+		if row.CreatedBy != 0 && row.CreatedBy == userID {
+			trailer := row
+			trailers = append(trailers, &trailer)
+		}
+	}
+
+	sortByID(trailers, func(trailer *Trailer) int64 { return trailer.ID })
+
+	return trailers, nil
+}
+
+type memoryDirectorModel struct {
+	s *memoryStore
+}
+
+func (d memoryDirectorModel) Insert(ctx context.Context, director *Director) error {
+	unlock, err := d.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	director.ID = d.s.nextID("directors")
+
+	row := *director
+	row.Awards = copyStrings(director.Awards)
+	d.s.t.directors[row.ID] = row
+
+	return d.s.insertEvent(EventDirectorCreated, EventAggregateDirector, director.ID, director)
+}
+
+func (d memoryDirectorModel) GetAll(ctx context.Context, name string, filters Filters) ([]*Director, Metadata, error) {
+	unlock, err := d.s.begin(ctx)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer unlock()
+
+	directors := []*Director{}
+
+	for _, row := range d.s.t.directors {
+		if matchesWords(row.Name, name) {
+			director := row
+			director.Awards = copyStrings(row.Awards)
+			director.CreatedBy = 0
+			directors = append(directors, &director)
+		}
+	}
+
+	directors, metadata := paginate(directors, filters, func(director *Director) int64 { return director.ID }, compareDirectors)
+
+	return directors, metadata, nil
+}
+
+func (d memoryDirectorModel) GetAllCreatedBy(ctx context.Context, userID int64) ([]*Director, error) {
+	unlock, err := d.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	directors := []*Director{}
+
+	for _, row := range d.s.t.directors {
+		if row.CreatedBy != 0 && row.CreatedBy == userID {
+			director := row
+			director.Awards = copyStrings(row.Awards)
+			directors = append(directors, &director)
+		}
+	}
+
+	sortByID(directors, func(director *Director) int64 { return director.ID })
+
+	return directors, nil
+}
+
+func compareDirectors(column string, a, b *Director) int {
+	switch column {
+	case "awards":
+		// array_length of an empty array is NULL, which Postgres sorts after
+		// every number.
+		switch {
+		case len(a.Awards) == 0 && len(b.Awards) == 0:
+			return 0
+		case len(a.Awards) == 0:
+			return 1
+		case len(b.Awards) == 0:
+			return -1
+		}
+		return compareInt64(int64(len(a.Awards)), int64(len(b.Awards)))
+	case "fullname":
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Surname, b.Surname)
+	}
+	return compareInt64(a.ID, b.ID)
+}
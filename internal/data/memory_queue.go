@@ -0,0 +1,849 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+type memoryOutboxModel struct {
+	s *memoryStore
+}
+
+func (m memoryOutboxModel) Insert(ctx context.Context, email *OutboxEmail) error {
+	js, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	now := time.Now()
+
+	email.ID = m.s.nextID("email_outbox")
+	email.Status = OutboxStatusPending
+	email.Attempts = 0
+	email.NextAttemptAt = now
+	email.CreatedAt = now
+
+	row := *email
+	row.LastError = ""
+	row.SentAt = nil
+	row.Data = nil
+
+	m.s.t.outbox[email.ID] = memoryOutboxEmail{email: row, data: js}
+
+	return nil
+}
+
+func (m memoryOutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	due := []memoryOutboxEmail{}
+
+	for _, row := range m.s.t.outbox {
+		if row.email.Status == OutboxStatusPending && !row.email.NextAttemptAt.After(now) {
+			due = append(due, row)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if c := compareTime(due[i].email.NextAttemptAt, due[j].email.NextAttemptAt); c != 0 {
+			return c < 0
+		}
+		return due[i].email.ID < due[j].email.ID
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	emails := []*OutboxEmail{}
+
+	for _, row := range due {
+		row.email.NextAttemptAt = now.Add(lease)
+		m.s.t.outbox[row.email.ID] = row
+
+		email, err := row.read()
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, email)
+	}
+
+	return emails, nil
+}
+
+func (m memoryOutboxModel) MarkSent(ctx context.Context, id int64) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.outbox[id]
+	if ok {
+		now := time.Now()
+		row.email.Status = OutboxStatusSent
+		row.email.Attempts++
+		row.email.LastError = ""
+		row.email.SentAt = &now
+		row.data = []byte("{}")
+		m.s.t.outbox[id] = row
+	}
+
+	return nil
+}
+
+func (m memoryOutboxModel) MarkFailed(ctx context.Context, id int64, sendErr error, nextAttemptAt time.Time, dead bool) error {
+	status := OutboxStatusPending
+	if dead {
+		status = OutboxStatusDead
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.outbox[id]
+	if ok {
+		row.email.Status = status
+		row.email.Attempts++
+		row.email.LastError = sendErr.Error()
+		row.email.NextAttemptAt = nextAttemptAt
+		m.s.t.outbox[id] = row
+	}
+
+	return nil
+}
+
+func (m memoryOutboxModel) Get(ctx context.Context, id int64) (*OutboxEmail, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.outbox[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return row.read()
+}
+
+func (m memoryOutboxModel) GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer unlock()
+
+	emails := []*OutboxEmail{}
+
+	for _, row := range m.s.t.outbox {
+		if status == "" || row.email.Status == status {
+			email, err := row.read()
+			if err != nil {
+				return nil, Metadata{}, err
+			}
+
+			emails = append(emails, email)
+		}
+	}
+
+	emails, metadata := paginate(emails, filters, func(email *OutboxEmail) int64 { return email.ID }, compareOutboxEmails)
+
+	return emails, metadata, nil
+}
+
+func (m memoryOutboxModel) Retry(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.outbox[id]
+	if !ok || row.email.Status != OutboxStatusDead {
+		return ErrRecordNotFound
+	}
+
+	row.email.Status = OutboxStatusPending
+	row.email.Attempts = 0
+	row.email.LastError = ""
+	row.email.NextAttemptAt = time.Now()
+	m.s.t.outbox[id] = row
+
+	return nil
+}
+
+func (m memoryOutboxModel) DeleteFinished(ctx context.Context, before time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+
+	for id, row := range m.s.t.outbox {
+		if (row.email.Status == OutboxStatusSent || row.email.Status == OutboxStatusDead) && row.email.CreatedAt.Before(before) {
+			delete(m.s.t.outbox, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// read returns a copy of the email with its data decoded from JSON, as it
+// comes back from the jsonb column in Postgres.
+func (row memoryOutboxEmail) read() (*OutboxEmail, error) {
+	email := row.email
+	email.SentAt = copyTime(row.email.SentAt)
+
+	err := json.Unmarshal(row.data, &email.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &email, nil
+}
+
+func compareOutboxEmails(column string, a, b *OutboxEmail) int {
+	switch column {
+	case "created_at":
+		return compareTime(a.CreatedAt, b.CreatedAt)
+	case "next_attempt_at":
+		return compareTime(a.NextAttemptAt, b.NextAttemptAt)
+	case "attempts":
+		return compareInt64(int64(a.Attempts), int64(b.Attempts))
+	}
+	return compareInt64(a.ID, b.ID)
+}
+
+type memoryJobModel struct {
+	s *memoryStore
+}
+
+func (m memoryJobModel) Insert(ctx context.Context, job *Job) error {
+	if len(job.Payload) == 0 {
+		job.Payload = json.RawMessage("{}")
+	}
+
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if job.UniqueKey != "" {
+		for _, row := range m.s.t.jobs {
+			if row.UniqueKey == job.UniqueKey {
+				return nil
+			}
+		}
+	}
+
+	job.ID = m.s.nextID("jobs")
+	job.Status = JobStatusPending
+	job.Attempts = 0
+	job.CreatedAt = time.Now()
+
+	m.s.t.jobs[job.ID] = Job{
+		ID:          job.ID,
+		Kind:        job.Kind,
+		Payload:     copyBytes(job.Payload),
+		Status:      job.Status,
+		MaxAttempts: job.MaxAttempts,
+		UniqueKey:   job.UniqueKey,
+		RunAt:       job.RunAt,
+		CreatedAt:   job.CreatedAt,
+	}
+
+	return nil
+}
+
+func (m memoryJobModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Job, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	due := []Job{}
+
+	for _, row := range m.s.t.jobs {
+		pending := row.Status == JobStatusPending && !row.RunAt.After(now)
+		abandoned := row.Status == JobStatusRunning && row.LockedUntil != nil && !row.LockedUntil.After(now)
+
+		if abandoned && row.Attempts >= row.MaxAttempts {
+			row.Status = JobStatusFailed
+			row.LastError = "lease expired on the last attempt"
+			row.LockedUntil = nil
+			row.FinishedAt = &now
+			m.s.t.jobs[row.ID] = row
+			continue
+		}
+
+		if pending || abandoned {
+			due = append(due, row)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		if c := compareTime(due[i].RunAt, due[j].RunAt); c != 0 {
+			return c < 0
+		}
+		return due[i].ID < due[j].ID
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	lockedUntil := now.Add(lease)
+	jobs := []*Job{}
+
+	for _, row := range due {
+		row.Status = JobStatusRunning
+		row.Attempts++
+		row.LockedUntil = &lockedUntil
+		m.s.t.jobs[row.ID] = row
+
+		jobs = append(jobs, readJob(row))
+	}
+
+	return jobs, nil
+}
+
+func (m memoryJobModel) Complete(ctx context.Context, id int64) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.jobs[id]
+	if ok && row.Status == JobStatusRunning {
+		now := time.Now()
+		row.Status = JobStatusSucceeded
+		row.LastError = ""
+		row.LockedUntil = nil
+		row.FinishedAt = &now
+		m.s.t.jobs[id] = row
+	}
+
+	return nil
+}
+
+func (m memoryJobModel) Fail(ctx context.Context, id int64, jobErr error, runAt time.Time, final bool) error {
+	status := JobStatusPending
+	var finishedAt *time.Time
+
+	if final {
+		now := time.Now()
+		status = JobStatusFailed
+		finishedAt = &now
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.jobs[id]
+	if ok && row.Status == JobStatusRunning {
+		row.Status = status
+		row.LastError = jobErr.Error()
+		row.RunAt = runAt
+		row.LockedUntil = nil
+		row.FinishedAt = finishedAt
+		m.s.t.jobs[id] = row
+	}
+
+	return nil
+}
+
+func (m memoryJobModel) Get(ctx context.Context, id int64) (*Job, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.jobs[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return readJob(row), nil
+}
+
+func (m memoryJobModel) GetAll(ctx context.Context, status, kind string, filters Filters) ([]*Job, Metadata, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer unlock()
+
+	jobs := []*Job{}
+
+	for _, row := range m.s.t.jobs {
+		if (status == "" || row.Status == status) && (kind == "" || row.Kind == kind) {
+			jobs = append(jobs, readJob(row))
+		}
+	}
+
+	jobs, metadata := paginate(jobs, filters, func(job *Job) int64 { return job.ID }, compareJobs)
+
+	return jobs, metadata, nil
+}
+
+func (m memoryJobModel) Retry(ctx context.Context, id int64) error {
+	return m.transition(ctx, id, func(job *Job, now time.Time) bool {
+		if job.Status != JobStatusFailed && job.Status != JobStatusCancelled {
+			return false
+		}
+
+		job.Status = JobStatusPending
+		job.Attempts = 0
+		job.LastError = ""
+		job.RunAt = now
+		job.FinishedAt = nil
+		return true
+	})
+}
+
+func (m memoryJobModel) Cancel(ctx context.Context, id int64) error {
+	return m.transition(ctx, id, func(job *Job, now time.Time) bool {
+		if job.Status != JobStatusPending {
+			return false
+		}
+
+		job.Status = JobStatusCancelled
+		job.FinishedAt = &now
+		return true
+	})
+}
+
+func (m memoryJobModel) DeleteFinished(ctx context.Context, before time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+
+	for id, row := range m.s.t.jobs {
+		if (row.Status == JobStatusSucceeded || row.Status == JobStatusCancelled) && row.FinishedAt != nil && row.FinishedAt.Before(before) {
+			delete(m.s.t.jobs, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// transition applies fn to the job, reporting ErrRecordNotFound if there is
+// no such job or fn finds it in the wrong state.
+func (m memoryJobModel) transition(ctx context.Context, id int64, fn func(job *Job, now time.Time) bool) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.jobs[id]
+	if !ok || !fn(&row, time.Now()) {
+		return ErrRecordNotFound
+	}
+
+	m.s.t.jobs[id] = row
+
+	return nil
+}
+
+func readJob(row Job) *Job {
+	row.Payload = copyBytes(row.Payload)
+	row.LockedUntil = copyTime(row.LockedUntil)
+	row.FinishedAt = copyTime(row.FinishedAt)
+	return &row
+}
+
+func compareJobs(column string, a, b *Job) int {
+	switch column {
+	case "created_at":
+		return compareTime(a.CreatedAt, b.CreatedAt)
+	case "run_at":
+		return compareTime(a.RunAt, b.RunAt)
+	case "attempts":
+		return compareInt64(int64(a.Attempts), int64(b.Attempts))
+	}
+	return compareInt64(a.ID, b.ID)
+}
+
+type memoryWebhookModel struct {
+	s *memoryStore
+}
+
+func (m memoryWebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	webhook.ID = m.s.nextID("webhooks")
+	webhook.CreatedAt = time.Now()
+	webhook.Version = 1
+
+	row := *webhook
+	row.Events = copyStrings(webhook.Events)
+	m.s.t.webhooks[row.ID] = row
+
+	return nil
+}
+
+func (m memoryWebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.webhooks[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	row.Events = copyStrings(row.Events)
+
+	return &row, nil
+}
+
+func (m memoryWebhookModel) GetAll(ctx context.Context) ([]*Webhook, error) {
+	return m.query(ctx, func(webhook Webhook) bool { return true })
+}
+
+func (m memoryWebhookModel) GetAllForEvent(ctx context.Context, event string) ([]*Webhook, error) {
+	return m.query(ctx, func(webhook Webhook) bool {
+		return webhook.Active && containsString(webhook.Events, event)
+	})
+}
+
+func (m memoryWebhookModel) query(ctx context.Context, match func(webhook Webhook) bool) ([]*Webhook, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	webhooks := []*Webhook{}
+
+	for _, row := range m.s.t.webhooks {
+		if match(row) {
+			webhook := row
+			webhook.Events = copyStrings(row.Events)
+			webhooks = append(webhooks, &webhook)
+		}
+	}
+
+	sortByID(webhooks, func(webhook *Webhook) int64 { return webhook.ID })
+
+	return webhooks, nil
+}
+
+func (m memoryWebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.webhooks[webhook.ID]
+	if !ok || row.Version != webhook.Version {
+		return ErrEditConflict
+	}
+
+	row.URL = webhook.URL
+	row.Events = copyStrings(webhook.Events)
+	row.Secret = webhook.Secret
+	row.Active = webhook.Active
+	row.Version++
+
+	m.s.t.webhooks[row.ID] = row
+
+	webhook.Version = row.Version
+
+	return nil
+}
+
+func (m memoryWebhookModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, ok := m.s.t.webhooks[id]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.t.webhooks, id)
+
+	for deliveryID, delivery := range m.s.t.deliveries {
+		if delivery.WebhookID == id {
+			delete(m.s.t.deliveries, deliveryID)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryWebhookModel) InsertDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delivery.ID = m.s.nextID("webhook_deliveries")
+	delivery.Status = DeliveryStatusPending
+	delivery.CreatedAt = time.Now()
+
+	m.s.t.deliveries[delivery.ID] = WebhookDelivery{
+		ID:        delivery.ID,
+		WebhookID: delivery.WebhookID,
+		Event:     delivery.Event,
+		Payload:   copyBytes(delivery.Payload),
+		Status:    delivery.Status,
+		CreatedAt: delivery.CreatedAt,
+	}
+
+	return nil
+}
+
+func (m memoryWebhookModel) GetDelivery(ctx context.Context, webhookID, id int64) (*WebhookDelivery, error) {
+	if webhookID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.deliveries[id]
+	if !ok || row.WebhookID != webhookID {
+		return nil, ErrRecordNotFound
+	}
+
+	return readDelivery(row), nil
+}
+
+func (m memoryWebhookModel) GetAllDeliveries(ctx context.Context, webhookID int64, filters Filters) ([]*WebhookDelivery, Metadata, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer unlock()
+
+	deliveries := []*WebhookDelivery{}
+
+	for _, row := range m.s.t.deliveries {
+		if row.WebhookID == webhookID {
+			deliveries = append(deliveries, readDelivery(row))
+		}
+	}
+
+	deliveries, metadata := paginate(deliveries, filters, func(delivery *WebhookDelivery) int64 { return delivery.ID }, compareDeliveries)
+
+	return deliveries, metadata, nil
+}
+
+func (m memoryWebhookModel) RecordAttempt(ctx context.Context, id int64, responseStatus int, sendErr error) error {
+	status := DeliveryStatusSucceeded
+	lastError := ""
+	var deliveredAt *time.Time
+
+	if sendErr != nil {
+		status = DeliveryStatusFailed
+		lastError = sendErr.Error()
+	} else {
+		now := time.Now()
+		deliveredAt = &now
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.deliveries[id]
+	if ok {
+		row.Status = status
+		row.Attempts++
+		row.ResponseStatus = responseStatus
+		row.LastError = lastError
+		row.DeliveredAt = deliveredAt
+		m.s.t.deliveries[id] = row
+	}
+
+	return nil
+}
+
+func (m memoryWebhookModel) DeleteDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+
+	for id, row := range m.s.t.deliveries {
+		if row.Status != DeliveryStatusPending && row.CreatedAt.Before(before) {
+			delete(m.s.t.deliveries, id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func readDelivery(row WebhookDelivery) *WebhookDelivery {
+	row.Payload = copyBytes(row.Payload)
+	row.DeliveredAt = copyTime(row.DeliveredAt)
+	return &row
+}
+
+func compareDeliveries(column string, a, b *WebhookDelivery) int {
+	switch column {
+	case "created_at":
+		return compareTime(a.CreatedAt, b.CreatedAt)
+	case "attempts":
+		return compareInt64(int64(a.Attempts), int64(b.Attempts))
+	}
+	return compareInt64(a.ID, b.ID)
+}
+
+type memoryEventModel struct {
+	s *memoryStore
+}
+
+func (m memoryEventModel) GetAfter(ctx context.Context, after int64, limit int) ([]*Event, error) {
+	return m.getAfter(ctx, "", after, limit)
+}
+
+func (m memoryEventModel) GetAfterForAggregate(ctx context.Context, aggregateType string, after int64, limit int) ([]*Event, error) {
+	return m.getAfter(ctx, aggregateType, after, limit)
+}
+
+// getAfter needs none of the snapshot checks of the Postgres model, since
+// events are only ever appended in ID order while the store is locked.
+func (m memoryEventModel) getAfter(ctx context.Context, aggregateType string, after int64, limit int) ([]*Event, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	events := []*Event{}
+
+	for _, row := range m.s.t.events {
+		if len(events) >= limit {
+			break
+		}
+
+		if row.ID > after && (aggregateType == "" || row.AggregateType == aggregateType) {
+			event := row
+			event.Payload = copyBytes(row.Payload)
+			events = append(events, &event)
+		}
+	}
+
+	return events, nil
+}
+
+func (m memoryEventModel) LatestID(ctx context.Context) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	if len(m.s.t.events) == 0 {
+		return 0, nil
+	}
+
+	return m.s.t.events[len(m.s.t.events)-1].ID, nil
+}
+
+func (m memoryEventModel) DeleteBefore(ctx context.Context, before time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	kept := m.s.t.events[:0:0]
+
+	for _, row := range m.s.t.events {
+		if !row.CreatedAt.Before(before) {
+			kept = append(kept, row)
+		}
+	}
+
+	count := int64(len(m.s.t.events) - len(kept))
+	m.s.t.events = kept
+
+	return count, nil
+}
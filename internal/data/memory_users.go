@@ -0,0 +1,972 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"time"
+)
+
+type memoryUserModel struct {
+	s *memoryStore
+}
+
+func (m memoryUserModel) Insert(ctx context.Context, user *User) error {
+	if user.Role == "" {
+		user.Role = "user"
+	}
+
+	if user.Language == "" {
+		user.Language = "en"
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = m.s.nextID("users")
+	user.CreatedAt = time.Now()
+	user.Version = 1
+
+	m.s.t.users[user.ID] = *user
+
+	return m.s.insertEvent(EventUserCreated, EventAggregateUser, user.ID, userEventPayload(user))
+}
+
+func (m memoryUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.users[id]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &row, nil
+}
+
+func (m memoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	for _, row := range m.s.t.users {
+		if strings.EqualFold(row.Email, email) {
+			return &row, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryUserModel) Update(ctx context.Context, user *User) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.users[user.ID]
+	if !ok || row.Version != user.Version {
+		return ErrEditConflict
+	}
+
+	if m.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	wasActivated := row.Activated
+
+	row.Name = user.Name
+	row.Email = user.Email
+	row.Password.hash = user.Password.hash
+	row.Activated = user.Activated
+	row.Language = user.Language
+	row.Version++
+
+	m.s.t.users[row.ID] = row
+
+	user.Version = row.Version
+
+	event := EventUserUpdated
+	if user.Activated && !wasActivated {
+		event = EventUserActivated
+	}
+
+	return m.s.insertEvent(event, EventAggregateUser, user.ID, userEventPayload(user))
+}
+
+func (m memoryUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	token, ok := m.s.t.tokens[string(tokenHash[:])]
+	if !ok || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	row, ok := m.s.t.users[token.UserID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	return &row, nil
+}
+
+func (m memoryUserModel) ScheduleDeletion(ctx context.Context, userID int64, scheduledFor time.Time) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m.s.t.accountDeletions[userID] = scheduledFor
+
+	payload := map[string]any{"id": userID, "scheduled_for": scheduledFor}
+
+	return m.s.insertEvent(EventUserDeletionScheduled, EventAggregateUser, userID, payload)
+}
+
+func (m memoryUserModel) CancelDeletion(ctx context.Context, userID int64) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, ok := m.s.t.accountDeletions[userID]; !ok {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.t.accountDeletions, userID)
+
+	return m.s.insertEvent(EventUserDeletionCancelled, EventAggregateUser, userID, map[string]any{"id": userID})
+}
+
+func (m memoryUserModel) DeleteScheduled(ctx context.Context, now time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var ids []int64
+
+	for id, scheduledFor := range m.s.t.accountDeletions {
+		if !scheduledFor.After(now) {
+			ids = append(ids, id)
+		}
+	}
+
+	return m.delete(ids)
+}
+
+func (m memoryUserModel) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var ids []int64
+
+	for id, row := range m.s.t.users {
+		if !row.Activated && !row.CreatedAt.After(createdBefore) {
+			ids = append(ids, id)
+		}
+	}
+
+	return m.delete(ids)
+}
+
+// delete removes the given users along with everything the schema cascades
+// to, revokes the JWTs issued to them, anonymises the catalogue entries they
+// created and drops the login history for their email addresses, as
+// DeleteScheduled does in Postgres.
+func (m memoryUserModel) delete(ids []int64) (int64, error) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	t := m.s.t
+	var count int64
+
+	for _, id := range ids {
+		user, ok := t.users[id]
+		if !ok {
+			continue
+		}
+
+		delete(t.users, id)
+		delete(t.accountDeletions, id)
+
+		for hash, token := range t.tokens {
+			if token.UserID == id {
+				delete(t.tokens, hash)
+			}
+		}
+		for keyID, key := range t.apiKeys {
+			if key.UserID == id {
+				delete(t.apiKeys, keyID)
+			}
+		}
+		for tokenID, issued := range t.issuedTokens {
+			if issued.userID == id {
+				if issued.expiry.After(time.Now()) {
+					t.revokedTokens[tokenID] = issued.expiry
+				}
+				delete(t.issuedTokens, tokenID)
+			}
+		}
+		for hash, export := range t.exports {
+			if export.userID == id {
+				delete(t.exports, hash)
+			}
+		}
+
+		identities := t.identities[:0:0]
+		for _, identity := range t.identities {
+			if identity.UserID != id {
+				identities = append(identities, identity)
+			}
+		}
+		t.identities = identities
+
+		for movieID, movie := range t.movies {
+			if movie.CreatedBy == id {
+				movie.CreatedBy = 0
+				t.movies[movieID] = movie
+			}
+		}
+		for directorID, director := range t.directors {
+			if director.CreatedBy == id {
+				director.CreatedBy = 0
+				t.directors[directorID] = director
+			}
+		}
+		for trailerID, trailer := range t.trailers {
+			if trailer.CreatedBy == id {
+				trailer.CreatedBy = 0
+				t.trailers[trailerID] = trailer
+			}
+		}
+
+		memoryLoginAttemptModel{m.s}.deleteAllForEmail(user.Email)
+		delete(t.lockouts, strings.ToLower(user.Email))
+
+		err := m.s.insertEvent(EventUserDeleted, EventAggregateUser, id, map[string]any{"id": id})
+		if err != nil {
+			return 0, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func (m memoryUserModel) emailTaken(email string, exceptID int64) bool {
+	for id, row := range m.s.t.users {
+		if id != exceptID && strings.EqualFold(row.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryTokenModel struct {
+	s *memoryStore
+}
+
+func (m memoryTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, token)
+	return token, err
+}
+
+func (m memoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m.s.t.tokens[string(token.Hash)] = Token{
+		Hash:   copyBytes(token.Hash),
+		UserID: token.UserID,
+		Expiry: token.Expiry,
+		Scope:  token.Scope,
+	}
+
+	return nil
+}
+
+func (m memoryTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for hash, token := range m.s.t.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(m.s.t.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (m memoryTokenModel) DeleteForPlaintext(ctx context.Context, scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	token, ok := m.s.t.tokens[string(tokenHash[:])]
+	if ok && token.Scope == scope {
+		delete(m.s.t.tokens, string(tokenHash[:]))
+	}
+
+	return nil
+}
+
+func (m memoryTokenModel) GetAllForUser(ctx context.Context, userID int64) ([]*Token, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	tokens := []*Token{}
+
+	for _, row := range m.s.t.tokens {
+		if row.UserID == userID {
+			tokens = append(tokens, &Token{UserID: row.UserID, Expiry: row.Expiry, Scope: row.Scope})
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Expiry.Before(tokens[j].Expiry) })
+
+	return tokens, nil
+}
+
+func (m memoryTokenModel) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+
+	for hash, token := range m.s.t.tokens {
+		if !token.Expiry.After(now) {
+			delete(m.s.t.tokens, hash)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+type memoryLoginAttemptModel struct {
+	s *memoryStore
+}
+
+func (m memoryLoginAttemptModel) Insert(ctx context.Context, email, ip string) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m.s.t.loginAttempts = append(m.s.t.loginAttempts, memoryLoginAttempt{email: email, ip: ip, createdAt: time.Now()})
+
+	return nil
+}
+
+func (m memoryLoginAttemptModel) Record(ctx context.Context, email, ip string, since time.Time) (int, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	m.s.t.loginAttempts = append(m.s.t.loginAttempts, memoryLoginAttempt{email: email, ip: ip, createdAt: time.Now()})
+
+	return m.countForEmail(email, since), nil
+}
+
+func (m memoryLoginAttemptModel) CountForEmail(ctx context.Context, email string, since time.Time) (int, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return m.countForEmail(email, since), nil
+}
+
+func (m memoryLoginAttemptModel) countForEmail(email string, since time.Time) int {
+	count := 0
+
+	for _, attempt := range m.s.t.loginAttempts {
+		if strings.EqualFold(attempt.email, email) && attempt.createdAt.After(since) {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (m memoryLoginAttemptModel) CountForIP(ctx context.Context, ip string, since time.Time) (int, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	count := 0
+
+	for _, attempt := range m.s.t.loginAttempts {
+		if attempt.ip == ip && attempt.createdAt.After(since) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m memoryLoginAttemptModel) DeleteAllForEmail(ctx context.Context, email string) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m.deleteAllForEmail(email)
+
+	return nil
+}
+
+func (m memoryLoginAttemptModel) deleteAllForEmail(email string) {
+	attempts := m.s.t.loginAttempts[:0:0]
+
+	for _, attempt := range m.s.t.loginAttempts {
+		if !strings.EqualFold(attempt.email, email) {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	m.s.t.loginAttempts = attempts
+}
+
+func (m memoryLoginAttemptModel) Lock(ctx context.Context, email string, until time.Time) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m.s.t.lockouts[strings.ToLower(email)] = until
+
+	return nil
+}
+
+func (m memoryLoginAttemptModel) GetLock(ctx context.Context, email string) (time.Time, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer unlock()
+
+	lockedUntil, ok := m.s.t.lockouts[strings.ToLower(email)]
+	if !ok || !lockedUntil.After(time.Now()) {
+		return time.Time{}, ErrRecordNotFound
+	}
+
+	return lockedUntil, nil
+}
+
+func (m memoryLoginAttemptModel) Unlock(ctx context.Context, email string) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	delete(m.s.t.lockouts, strings.ToLower(email))
+	m.deleteAllForEmail(email)
+
+	return nil
+}
+
+func (m memoryLoginAttemptModel) DeleteStale(ctx context.Context, since, now time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	attempts := m.s.t.loginAttempts[:0:0]
+
+	for _, attempt := range m.s.t.loginAttempts {
+		if attempt.createdAt.After(since) {
+			attempts = append(attempts, attempt)
+		}
+	}
+
+	count := int64(len(m.s.t.loginAttempts) - len(attempts))
+	m.s.t.loginAttempts = attempts
+
+	for email, lockedUntil := range m.s.t.lockouts {
+		if !lockedUntil.After(now) {
+			delete(m.s.t.lockouts, email)
+		}
+	}
+
+	return count, nil
+}
+
+type memoryAPIKeyModel struct {
+	s *memoryStore
+}
+
+func (m memoryAPIKeyModel) New(ctx context.Context, userID int64, name string, expiry *time.Time, permissions Permissions) (*APIKey, error) {
+	key, err := generateAPIKey(userID, name, expiry, permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.Insert(ctx, key)
+	return key, err
+}
+
+func (m memoryAPIKeyModel) Insert(ctx context.Context, key *APIKey) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	key.ID = m.s.nextID("api_keys")
+	key.CreatedAt = time.Now()
+
+	m.s.t.apiKeys[key.ID] = APIKey{
+		ID:          key.ID,
+		UserID:      key.UserID,
+		Name:        key.Name,
+		Hash:        copyBytes(key.Hash),
+		Permissions: Permissions(copyStrings(key.Permissions)),
+		CreatedAt:   key.CreatedAt,
+		Expiry:      copyTime(key.Expiry),
+	}
+
+	return nil
+}
+
+func (m memoryAPIKeyModel) GetForPlaintext(ctx context.Context, keyPlaintext string) (*APIKey, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+
+	for _, row := range m.s.t.apiKeys {
+		if string(row.Hash) == string(keyHash[:]) && (row.Expiry == nil || row.Expiry.After(now)) {
+			return m.read(row), nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryAPIKeyModel) GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	keys := []*APIKey{}
+
+	for _, row := range m.s.t.apiKeys {
+		if row.UserID == userID {
+			keys = append(keys, m.read(row))
+		}
+	}
+
+	sortByID(keys, func(key *APIKey) int64 { return key.ID })
+
+	return keys, nil
+}
+
+func (m memoryAPIKeyModel) UpdateLastUsed(ctx context.Context, id int64) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.apiKeys[id]
+	if ok {
+		now := time.Now()
+		row.LastUsedAt = &now
+		m.s.t.apiKeys[id] = row
+	}
+
+	return nil
+}
+
+func (m memoryAPIKeyModel) Delete(ctx context.Context, id, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.apiKeys[id]
+	if !ok || row.UserID != userID {
+		return ErrRecordNotFound
+	}
+
+	delete(m.s.t.apiKeys, id)
+
+	return nil
+}
+
+func (m memoryAPIKeyModel) DeleteAllForUser(ctx context.Context, userID int64) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for id, row := range m.s.t.apiKeys {
+		if row.UserID == userID {
+			delete(m.s.t.apiKeys, id)
+		}
+	}
+
+	return nil
+}
+
+// read returns a copy of row without the hash, which the Postgres model
+// never selects.
+func (m memoryAPIKeyModel) read(row APIKey) *APIKey {
+	row.Hash = nil
+	row.Permissions = Permissions(copyStrings(row.Permissions))
+	row.Expiry = copyTime(row.Expiry)
+	row.LastUsedAt = copyTime(row.LastUsedAt)
+	return &row
+}
+
+type memoryRevokedTokenModel struct {
+	s *memoryStore
+}
+
+func (m memoryRevokedTokenModel) Insert(ctx context.Context, id string, expiry time.Time) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, ok := m.s.t.revokedTokens[id]; !ok {
+		m.s.t.revokedTokens[id] = expiry
+	}
+
+	return nil
+}
+
+func (m memoryRevokedTokenModel) InsertIssued(ctx context.Context, id string, userID int64, expiry time.Time) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m.s.t.issuedTokens[id] = memoryIssuedToken{userID: userID, expiry: expiry}
+
+	return nil
+}
+
+func (m memoryRevokedTokenModel) RevokeAllForUser(ctx context.Context, userID int64) (map[string]time.Time, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	revoked := make(map[string]time.Time)
+
+	for id, issued := range m.s.t.issuedTokens {
+		if _, ok := m.s.t.revokedTokens[id]; ok {
+			continue
+		}
+
+		if issued.userID == userID && issued.expiry.After(now) {
+			m.s.t.revokedTokens[id] = issued.expiry
+			revoked[id] = issued.expiry
+		}
+	}
+
+	return revoked, nil
+}
+
+func (m memoryRevokedTokenModel) GetAllActive(ctx context.Context) (map[string]time.Time, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	revoked := make(map[string]time.Time)
+
+	for id, expiry := range m.s.t.revokedTokens {
+		if expiry.After(now) {
+			revoked[id] = expiry
+		}
+	}
+
+	return revoked, nil
+}
+
+func (m memoryRevokedTokenModel) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+
+	for id, expiry := range m.s.t.revokedTokens {
+		if !expiry.After(now) {
+			delete(m.s.t.revokedTokens, id)
+			count++
+		}
+	}
+
+	for id, issued := range m.s.t.issuedTokens {
+		if !issued.expiry.After(now) {
+			delete(m.s.t.issuedTokens, id)
+		}
+	}
+
+	return count, nil
+}
+
+type memoryUserIdentityModel struct {
+	s *memoryStore
+}
+
+func (m memoryUserIdentityModel) Insert(ctx context.Context, identity *UserIdentity) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for _, row := range m.s.t.identities {
+		if row.Provider == identity.Provider && row.Subject == identity.Subject {
+			return ErrDuplicateIdentity
+		}
+	}
+
+	identity.CreatedAt = time.Now()
+	m.s.t.identities = append(m.s.t.identities, *identity)
+
+	return nil
+}
+
+func (m memoryUserIdentityModel) GetUser(ctx context.Context, provider, subject string) (*User, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	for _, row := range m.s.t.identities {
+		if row.Provider == provider && row.Subject == subject {
+			user, ok := m.s.t.users[row.UserID]
+			if !ok {
+				break
+			}
+			return &user, nil
+		}
+	}
+
+	return nil, ErrRecordNotFound
+}
+
+func (m memoryUserIdentityModel) GetAllForUser(ctx context.Context, userID int64) ([]*UserIdentity, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	identities := []*UserIdentity{}
+
+	for _, row := range m.s.t.identities {
+		if row.UserID == userID {
+			identity := row
+			identities = append(identities, &identity)
+		}
+	}
+
+	sort.SliceStable(identities, func(i, j int) bool { return identities[i].CreatedAt.Before(identities[j].CreatedAt) })
+
+	return identities, nil
+}
+
+func (m memoryUserIdentityModel) InsertState(ctx context.Context, state *OAuthState) error {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, ok := m.s.t.oauthStates[state.State]; ok {
+		return errDuplicateOAuthState
+	}
+
+	m.s.t.oauthStates[state.State] = *state
+
+	return nil
+}
+
+func (m memoryUserIdentityModel) ConsumeState(ctx context.Context, provider, state string) (*OAuthState, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	row, ok := m.s.t.oauthStates[state]
+	if !ok || row.Provider != provider || !row.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	delete(m.s.t.oauthStates, state)
+
+	return &row, nil
+}
+
+func (m memoryUserIdentityModel) DeleteExpiredStates(ctx context.Context, now time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+
+	for state, row := range m.s.t.oauthStates {
+		if !row.Expiry.After(now) {
+			delete(m.s.t.oauthStates, state)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+type memoryExportModel struct {
+	s *memoryStore
+}
+
+func (m memoryExportModel) New(ctx context.Context, userID int64, ttl time.Duration, archive []byte) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeExport)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return token, err
+	}
+	defer unlock()
+
+	m.s.t.exports[string(token.Hash)] = memoryExport{
+		userID:  userID,
+		archive: copyBytes(archive),
+		expiry:  token.Expiry,
+	}
+
+	return token, nil
+}
+
+func (m memoryExportModel) GetArchive(ctx context.Context, tokenPlaintext string) ([]byte, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	export, ok := m.s.t.exports[string(tokenHash[:])]
+	if !ok || !export.expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyBytes(export.archive), nil
+}
+
+func (m memoryExportModel) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	unlock, err := m.s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	var count int64
+
+	for hash, export := range m.s.t.exports {
+		if !export.expiry.After(now) {
+			delete(m.s.t.exports, hash)
+			count++
+		}
+	}
+
+	return count, nil
+}
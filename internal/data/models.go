@@ -1,29 +1,133 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
+	"time"
 )
 
+// DefaultQueryTimeout bounds queries run by models created without a
+// timeout of their own.
+const DefaultQueryTimeout = 3 * time.Second
+
 var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
+	ErrQueryCanceled  = errors.New("query canceled")
+	ErrQueryTimeout   = errors.New("query timed out")
 )
 
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Models struct {
-	Movies    MovieModel
-	Trailers  TrailerModel
-	Directors DirectorModel
-	Users     UserModel
-	Tokens    TokenModel
+	Movies        MovieRepository
+	Trailers      TrailerRepository
+	Directors     DirectorRepository
+	Users         UserRepository
+	Tokens        TokenRepository
+	LoginAttempts LoginAttemptRepository
+	APIKeys       APIKeyRepository
+	RevokedTokens RevokedTokenRepository
+	Identities    UserIdentityRepository
+	Exports       ExportRepository
+	Outbox        OutboxRepository
+	Jobs          JobRepository
+	Webhooks      WebhookRepository
+	Events        EventRepository
+
+	db      *sql.DB
+	timeout time.Duration
+	memory  *memoryStore
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns models whose queries are each given up to timeout to
+// complete, on top of any deadline of the context they are called with.
+func NewModels(db *sql.DB, timeout time.Duration) Models {
+	models := newModels(db, timeout)
+	models.db = db
+
+	return models
+}
+
+func newModels(db DBTX, timeout time.Duration) Models {
 	return Models{
-		Movies:    MovieModel{DB: db},
-		Trailers:  TrailerModel{DB: db},
-		Directors: DirectorModel{DB: db},
-		Users:     UserModel{DB: db},
-		Tokens:    TokenModel{DB: db},
+		Movies:        MovieModel{DB: db, Timeout: timeout},
+		Trailers:      TrailerModel{DB: db, Timeout: timeout},
+		Directors:     DirectorModel{DB: db, Timeout: timeout},
+		Users:         UserModel{DB: db, Timeout: timeout},
+		Tokens:        TokenModel{DB: db, Timeout: timeout},
+		LoginAttempts: LoginAttemptModel{DB: db, Timeout: timeout},
+		APIKeys:       APIKeyModel{DB: db, Timeout: timeout},
+		RevokedTokens: RevokedTokenModel{DB: db, Timeout: timeout},
+		Identities:    UserIdentityModel{DB: db, Timeout: timeout},
+		Exports:       ExportModel{DB: db, Timeout: timeout},
+		Outbox:        OutboxModel{DB: db, Timeout: timeout},
+		Jobs:          JobModel{DB: db, Timeout: timeout},
+		Webhooks:      WebhookModel{DB: db, Timeout: timeout},
+		Events:        EventModel{DB: db, Timeout: timeout},
+		timeout:       timeout,
 	}
 }
+
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryError replaces the error of a query that failed because ctx was
+// cancelled or ran out of time, which Postgres reports as a cancelled
+// statement, with ErrQueryCanceled or ErrQueryTimeout. A statement cancelled
+// by the server's own statement_timeout counts as a timeout too.
+func queryError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrQueryCanceled) || errors.Is(err, ErrQueryTimeout) {
+		return err
+	}
+
+	switch ctx.Err() {
+	case context.Canceled:
+		return ErrQueryCanceled
+	case context.DeadlineExceeded:
+		return ErrQueryTimeout
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "57014" {
+		return ErrQueryTimeout
+	}
+
+	return err
+}
+
+// Transaction runs fn with a set of models bound to a single database
+// transaction, committing if fn returns nil and rolling back otherwise.
+func (m Models) Transaction(ctx context.Context, fn func(tx Models) error) error {
+	if m.memory != nil && !m.memory.locked {
+		return m.memory.transaction(ctx, fn)
+	}
+
+	if m.db == nil {
+		return errors.New("models are not bound to a database")
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	err = fn(newModels(tx, m.timeout))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return queryError(ctx, tx.Commit())
+}
@@ -0,0 +1,312 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusDead    = "dead"
+)
+
+type OutboxEmail struct {
+	ID            int64          `json:"id"`
+	Recipient     string         `json:"recipient"`
+	Template      string         `json:"template"`
+	Data          map[string]any `json:"-"`
+	Status        string         `json:"status"`
+	Attempts      int            `json:"attempts"`
+	LastError     string         `json:"last_error,omitempty"`
+	NextAttemptAt time.Time      `json:"next_attempt_at"`
+	CreatedAt     time.Time      `json:"created_at"`
+	SentAt        *time.Time     `json:"sent_at,omitempty"`
+}
+
+type OutboxModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+func (m OutboxModel) Insert(ctx context.Context, email *OutboxEmail) error {
+	js, err := json.Marshal(email.Data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO email_outbox (recipient, template, data)
+		VALUES ($1, $2, $3)
+		RETURNING id, status, attempts, next_attempt_at, created_at`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, email.Recipient, email.Template, js).Scan(
+		&email.ID,
+		&email.Status,
+		&email.Attempts,
+		&email.NextAttemptAt,
+		&email.CreatedAt,
+	)
+}
+
+// Claim picks up to limit due emails and leases them for the given duration
+// by pushing their next attempt into the future, so other workers skip them
+// and they are retried automatically if this process dies mid-send.
+func (m OutboxModel) Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error) {
+	query := `
+		UPDATE email_outbox
+		SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id
+			FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= $2
+			ORDER BY next_attempt_at ASC, id ASC
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, template, data, status, attempts, last_error, next_attempt_at, created_at, sent_at`
+
+	now := time.Now()
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, now.Add(lease), now, limit)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	emails := []*OutboxEmail{}
+
+	for rows.Next() {
+		email, err := scanOutboxEmail(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		emails = append(emails, email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return emails, nil
+}
+
+// MarkSent records the delivery of an email and clears its data, which holds
+// tokens and links that have no business outliving the send.
+func (m OutboxModel) MarkSent(ctx context.Context, id int64) error {
+	query := `
+		UPDATE email_outbox
+		SET status = 'sent', attempts = attempts + 1, last_error = '', sent_at = $1, data = '{}'
+		WHERE id = $2`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	return queryError(ctx, err)
+}
+
+// MarkFailed schedules another attempt at the email, or gives up on it when
+// dead is set. Dead emails keep their data so an admin can retry them, until
+// DeleteFinished removes them after the outbox retention.
+func (m OutboxModel) MarkFailed(ctx context.Context, id int64, sendErr error, nextAttemptAt time.Time, dead bool) error {
+	status := OutboxStatusPending
+	if dead {
+		status = OutboxStatusDead
+	}
+
+	query := `
+		UPDATE email_outbox
+		SET status = $1, attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+		WHERE id = $4`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, status, sendErr.Error(), nextAttemptAt, id)
+	return queryError(ctx, err)
+}
+
+func (m OutboxModel) Get(ctx context.Context, id int64) (*OutboxEmail, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, recipient, template, data, status, attempts, last_error, next_attempt_at, created_at, sent_at
+		FROM email_outbox
+		WHERE id = $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	email, err := scanOutboxEmail(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, queryError(ctx, err)
+		}
+	}
+
+	return email, nil
+}
+
+func (m OutboxModel) GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, recipient, template, data, status, attempts, last_error, next_attempt_at, created_at, sent_at
+		FROM email_outbox
+		WHERE (status = $1 OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	emails := []*OutboxEmail{}
+
+	for rows.Next() {
+		var (
+			email OutboxEmail
+			js    []byte
+		)
+
+		err := rows.Scan(
+			&totalRecords,
+			&email.ID,
+			&email.Recipient,
+			&email.Template,
+			&js,
+			&email.Status,
+			&email.Attempts,
+			&email.LastError,
+			&email.NextAttemptAt,
+			&email.CreatedAt,
+			&email.SentAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		err = json.Unmarshal(js, &email.Data)
+		if err != nil {
+			return nil, Metadata{}, queryError(ctx, err)
+		}
+
+		emails = append(emails, &email)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, queryError(ctx, err)
+	}
+
+	metadata := CalculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return emails, metadata, nil
+}
+
+// Retry puts a dead-lettered email back in the queue with a fresh set of
+// attempts. It returns ErrRecordNotFound for emails in any other state: sent
+// emails have lost their data, and pending ones may be mid-send.
+func (m OutboxModel) Retry(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE email_outbox
+		SET status = 'pending', attempts = 0, last_error = '', next_attempt_at = $1
+		WHERE id = $2 AND status = 'dead'`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteFinished deletes the sent and dead-lettered emails created before the
+// given time.
+func (m OutboxModel) DeleteFinished(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM email_outbox
+		WHERE status IN ('sent', 'dead') AND created_at < $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOutboxEmail(row scanner) (*OutboxEmail, error) {
+	var (
+		email OutboxEmail
+		js    []byte
+	)
+
+	err := row.Scan(
+		&email.ID,
+		&email.Recipient,
+		&email.Template,
+		&js,
+		&email.Status,
+		&email.Attempts,
+		&email.LastError,
+		&email.NextAttemptAt,
+		&email.CreatedAt,
+		&email.SentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(js, &email.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &email, nil
+}
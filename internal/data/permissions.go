@@ -0,0 +1,41 @@
+package data
+
+const (
+	PermissionMoviesRead     = "movies:read"
+	PermissionMoviesWrite    = "movies:write"
+	PermissionDirectorsRead  = "directors:read"
+	PermissionDirectorsWrite = "directors:write"
+	PermissionTrailersWrite  = "trailers:write"
+	PermissionWebhooksManage = "webhooks:manage"
+	PermissionEventsRead     = "events:read"
+)
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func PermissionsForRole(role string) Permissions {
+	if role == "admin" {
+		return Permissions{
+			PermissionMoviesRead,
+			PermissionMoviesWrite,
+			PermissionDirectorsRead,
+			PermissionDirectorsWrite,
+			PermissionTrailersWrite,
+			PermissionWebhooksManage,
+			PermissionEventsRead,
+		}
+	}
+
+	return Permissions{
+		PermissionMoviesRead,
+		PermissionDirectorsRead,
+	}
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// The repository interfaces describe what the rest of the application needs
+// from each model. The Postgres models implement them, and so do the
+// in-memory ones returned by NewMemoryModels.
+
+type MovieRepository interface {
+	Insert(ctx context.Context, movie *Movie) error
+	Get(ctx context.Context, id int64) (*Movie, error)
+	Update(ctx context.Context, movie *Movie) error
+	Delete(ctx context.Context, id int64) error
+	GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, error)
+	GetAllCreatedBy(ctx context.Context, userID int64) ([]*Movie, error)
+}
+
+type TrailerRepository interface {
+	Insert(ctx context.Context, trailer *Trailer) error
+	GetAllCreatedBy(ctx context.Context, userID int64) ([]*Trailer, error)
+}
+
+type DirectorRepository interface {
+	Insert(ctx context.Context, director *Director) error
+	GetAll(ctx context.Context, name string, filters Filters) ([]*Director, Metadata, error)
+	GetAllCreatedBy(ctx context.Context, userID int64) ([]*Director, error)
+}
+
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+	ScheduleDeletion(ctx context.Context, userID int64, scheduledFor time.Time) error
+	CancelDeletion(ctx context.Context, userID int64) error
+	DeleteScheduled(ctx context.Context, now time.Time) (int64, error)
+	DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error)
+}
+
+type TokenRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+	DeleteForPlaintext(ctx context.Context, scope, tokenPlaintext string) error
+	GetAllForUser(ctx context.Context, userID int64) ([]*Token, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type LoginAttemptRepository interface {
+	Insert(ctx context.Context, email, ip string) error
+	Record(ctx context.Context, email, ip string, since time.Time) (int, error)
+	CountForEmail(ctx context.Context, email string, since time.Time) (int, error)
+	CountForIP(ctx context.Context, ip string, since time.Time) (int, error)
+	DeleteAllForEmail(ctx context.Context, email string) error
+	Lock(ctx context.Context, email string, until time.Time) error
+	GetLock(ctx context.Context, email string) (time.Time, error)
+	Unlock(ctx context.Context, email string) error
+	DeleteStale(ctx context.Context, since, now time.Time) (int64, error)
+}
+
+type APIKeyRepository interface {
+	New(ctx context.Context, userID int64, name string, expiry *time.Time, permissions Permissions) (*APIKey, error)
+	Insert(ctx context.Context, key *APIKey) error
+	GetForPlaintext(ctx context.Context, keyPlaintext string) (*APIKey, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*APIKey, error)
+	UpdateLastUsed(ctx context.Context, id int64) error
+	Delete(ctx context.Context, id, userID int64) error
+	DeleteAllForUser(ctx context.Context, userID int64) error
+}
+
+type RevokedTokenRepository interface {
+	Insert(ctx context.Context, id string, expiry time.Time) error
+	InsertIssued(ctx context.Context, id string, userID int64, expiry time.Time) error
+	RevokeAllForUser(ctx context.Context, userID int64) (map[string]time.Time, error)
+	GetAllActive(ctx context.Context) (map[string]time.Time, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type UserIdentityRepository interface {
+	Insert(ctx context.Context, identity *UserIdentity) error
+	GetUser(ctx context.Context, provider, subject string) (*User, error)
+	GetAllForUser(ctx context.Context, userID int64) ([]*UserIdentity, error)
+	InsertState(ctx context.Context, state *OAuthState) error
+	ConsumeState(ctx context.Context, provider, state string) (*OAuthState, error)
+	DeleteExpiredStates(ctx context.Context, now time.Time) (int64, error)
+}
+
+type ExportRepository interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, archive []byte) (*Token, error)
+	GetArchive(ctx context.Context, tokenPlaintext string) ([]byte, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type OutboxRepository interface {
+	Insert(ctx context.Context, email *OutboxEmail) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEmail, error)
+	MarkSent(ctx context.Context, id int64) error
+	MarkFailed(ctx context.Context, id int64, sendErr error, nextAttemptAt time.Time, dead bool) error
+	Get(ctx context.Context, id int64) (*OutboxEmail, error)
+	GetAll(ctx context.Context, status string, filters Filters) ([]*OutboxEmail, Metadata, error)
+	Retry(ctx context.Context, id int64) error
+	DeleteFinished(ctx context.Context, before time.Time) (int64, error)
+}
+
+type JobRepository interface {
+	Insert(ctx context.Context, job *Job) error
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*Job, error)
+	Complete(ctx context.Context, id int64) error
+	Fail(ctx context.Context, id int64, jobErr error, runAt time.Time, final bool) error
+	Get(ctx context.Context, id int64) (*Job, error)
+	GetAll(ctx context.Context, status, kind string, filters Filters) ([]*Job, Metadata, error)
+	Retry(ctx context.Context, id int64) error
+	Cancel(ctx context.Context, id int64) error
+	DeleteFinished(ctx context.Context, before time.Time) (int64, error)
+}
+
+type WebhookRepository interface {
+	Insert(ctx context.Context, webhook *Webhook) error
+	Get(ctx context.Context, id int64) (*Webhook, error)
+	GetAll(ctx context.Context) ([]*Webhook, error)
+	GetAllForEvent(ctx context.Context, event string) ([]*Webhook, error)
+	Update(ctx context.Context, webhook *Webhook) error
+	Delete(ctx context.Context, id int64) error
+	InsertDelivery(ctx context.Context, delivery *WebhookDelivery) error
+	GetDelivery(ctx context.Context, webhookID, id int64) (*WebhookDelivery, error)
+	GetAllDeliveries(ctx context.Context, webhookID int64, filters Filters) ([]*WebhookDelivery, Metadata, error)
+	RecordAttempt(ctx context.Context, id int64, responseStatus int, sendErr error) error
+	DeleteDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
+
+type EventRepository interface {
+	GetAfter(ctx context.Context, after int64, limit int) ([]*Event, error)
+	GetAfterForAggregate(ctx context.Context, aggregateType string, after int64, limit int) ([]*Event, error)
+	LatestID(ctx context.Context) (int64, error)
+	DeleteBefore(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type RevokedTokenModel struct {
+	DB      DBTX
+	Timeout time.Duration
+}
+
+func (m RevokedTokenModel) Insert(ctx context.Context, id string, expiry time.Time) error {
+	query := `
+		INSERT INTO revoked_tokens (id, expiry)
+		VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, expiry)
+	return queryError(ctx, err)
+}
+
+// InsertIssued records a token issued to a user, so that RevokeAllForUser
+// can revoke it later on.
+func (m RevokedTokenModel) InsertIssued(ctx context.Context, id string, userID int64, expiry time.Time) error {
+	query := `
+		INSERT INTO issued_tokens (id, user_id, expiry)
+		VALUES ($1, $2, $3)`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, userID, expiry)
+	return queryError(ctx, err)
+}
+
+// RevokeAllForUser revokes every unexpired token issued to a user and
+// returns them.
+func (m RevokedTokenModel) RevokeAllForUser(ctx context.Context, userID int64) (map[string]time.Time, error) {
+	query := `
+		INSERT INTO revoked_tokens (id, expiry)
+		SELECT id, expiry FROM issued_tokens
+		WHERE user_id = $1 AND expiry > $2
+		ON CONFLICT (id) DO NOTHING
+		RETURNING id, expiry`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return scanRevokedTokens(ctx, rows)
+}
+
+func (m RevokedTokenModel) GetAllActive(ctx context.Context) (map[string]time.Time, error) {
+	query := `
+		SELECT id, expiry
+		FROM revoked_tokens
+		WHERE expiry > $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return scanRevokedTokens(ctx, rows)
+}
+
+func scanRevokedTokens(ctx context.Context, rows *sql.Rows) (map[string]time.Time, error) {
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+
+	for rows.Next() {
+		var (
+			id     string
+			expiry time.Time
+		)
+
+		err := rows.Scan(&id, &expiry)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		revoked[id] = expiry
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return revoked, nil
+}
+
+// DeleteExpired removes the revoked and issued tokens that have expired,
+// counting the revoked ones.
+func (m RevokedTokenModel) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	query := `
+		WITH issued AS (
+			DELETE FROM issued_tokens WHERE expiry <= $1
+		)
+		DELETE FROM revoked_tokens
+		WHERE expiry <= $1`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, now)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+
+	return result.RowsAffected()
+}
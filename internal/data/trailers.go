@@ -1,7 +1,8 @@
 package data
 
 import (
-	"database/sql"
+	"context"
+	"time"
 )
 
 type Trailer struct {
@@ -9,18 +10,73 @@ type Trailer struct {
 	Trailer_name string `json:"trailer_name"`
 	Duration     int64  `json:"duration"`
 	Premier_date string `json:"premier_date"`
+	CreatedBy    int64  `json:"-"`
 }
 
 type TrailerModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Timeout time.Duration
 }
 
-func (t TrailerModel) Insert(trailer *Trailer) error {
-	query := `INSERT INTO trailers (trailer_name, duration, premier_date)
-			  VALUES ($1, $2, $3)
+func (t TrailerModel) Insert(ctx context.Context, trailer *Trailer) error {
+	query := `INSERT INTO trailers (trailer_name, duration, premier_date, created_by)
+			  VALUES ($1, $2, $3, NULLIF($4, 0))
 			  RETURNING id`
 
-	args := []any{trailer.Trailer_name, trailer.Duration, trailer.Premier_date}
+	args := []any{trailer.Trailer_name, trailer.Duration, trailer.Premier_date, trailer.CreatedBy}
 
-	return t.DB.QueryRow(query, args...).Scan(&trailer.ID)
+	return withTx(ctx, t.DB, func(tx DBTX) error {
+		ctx, cancel := withQueryTimeout(ctx, t.Timeout)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&trailer.ID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		return insertEvent(ctx, tx, EventTrailerCreated, EventAggregateTrailer, trailer.ID, trailer)
+	})
+}
+
+func (t TrailerModel) GetAllCreatedBy(ctx context.Context, userID int64) ([]*Trailer, error) {
+	query := `
+		SELECT id, trailer_name, duration, premier_date
+		FROM trailers
+		WHERE created_by = $1
+		ORDER BY id ASC`
+
+	ctx, cancel := withQueryTimeout(ctx, t.Timeout)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	defer rows.Close()
+
+	trailers := []*Trailer{}
+
+	for rows.Next() {
+		var trailer Trailer
+
+		err := rows.Scan(
+			&trailer.ID,
+			&trailer.Trailer_name,
+			&trailer.Duration,
+			&trailer.Premier_date,
+		)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+
+		trailer.CreatedBy = userID
+		trailers = append(trailers, &trailer)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+
+	return trailers, nil
 }
@@ -24,6 +24,7 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Role      string    `json:"role"`
+	Language  string    `json:"language"`
 	Version   int       `json:"-"`
 }
 
@@ -48,7 +49,22 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// SetUnusable leaves the user without a password to sign in with, for those
+// who signed up through an identity provider.
+func (p *password) SetUnusable() {
+	p.plaintext = nil
+	p.hash = []byte{}
+}
+
+func (p *password) IsUsable() bool {
+	return len(p.hash) > 0
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
+	if !p.IsUsable() {
+		return false, nil
+	}
+
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
 		switch {
@@ -89,46 +105,92 @@ func ValidateUser(v *validator.Validator, user *User) {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB      DBTX
+	Timeout time.Duration
 }
 
-func (m UserModel) Insert(user *User) error {
+func (m UserModel) Insert(ctx context.Context, user *User) error {
 	query := `
-		INSERT INTO users (name, email, password_hash, activated, role)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO users (name, email, password_hash, activated, role, language)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id, created_at, version`
 
 	if user.Role == "" {
 		user.Role = "user"
 	}
 
-	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.Role}
+	if user.Language == "" {
+		user.Language = "en"
+	}
+
+	args := []any{user.Name, user.Email, user.Password.hash, user.Activated, user.Role, user.Language}
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+		defer cancel()
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return queryError(ctx, err)
+			}
+		}
+
+		return insertEvent(ctx, tx, EventUserCreated, EventAggregateUser, user.ID, userEventPayload(user))
+	})
+}
+
+func (m UserModel) Get(ctx context.Context, id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, email, password_hash, activated, role, language, version
+		FROM users
+		WHERE id = $1`
+
+	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Role,
+		&user.Language,
+		&user.Version,
+	)
+
 	if err != nil {
 		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, queryError(ctx, err)
 		}
 	}
 
-	return nil
+	return &user, nil
 }
 
-func (m UserModel) GetByEmail(email string) (*User, error) {
+func (m UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, role, version
+		SELECT id, created_at, name, email, password_hash, activated, role, language, version
 		FROM users
 		WHERE email = $1`
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
@@ -139,6 +201,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Password.hash,
 		&user.Activated,
 		&user.Role,
+		&user.Language,
 		&user.Version,
 	)
 
@@ -147,52 +210,69 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
 	return &user, nil
 }
 
-func (m UserModel) Update(user *User) error {
+func (m UserModel) Update(ctx context.Context, user *User) error {
 	query := `
-		UPDATE users
-		SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-		WHERE id = $5 AND version = $6
-		RETURNING version`
+		UPDATE users u
+		SET name = $1, email = $2, password_hash = $3, activated = $4, language = $5, version = u.version + 1
+		FROM users old
+		WHERE u.id = old.id AND u.id = $6 AND u.version = $7
+		RETURNING u.version, old.activated`
 
 	args := []any{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Language,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+		defer cancel()
+
+		var wasActivated bool
+
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.Version, &wasActivated)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return queryError(ctx, err)
+			}
+		}
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
-	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
-		default:
-			return err
+		event := EventUserUpdated
+		if user.Activated && !wasActivated {
+			event = EventUserActivated
 		}
-	}
 
-	return nil
+		return insertEvent(ctx, tx, event, EventAggregateUser, user.ID, userEventPayload(user))
+	})
+}
+
+// userEventPayload keeps names and email addresses out of the events table,
+// where they would outlive the deletion of the account.
+func userEventPayload(user *User) map[string]any {
+	return map[string]any{"id": user.ID}
 }
 
-func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+func (m UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, role, version
+		SELECT id, created_at, name, email, password_hash, activated, role, language, version
 		FROM users
 		INNER JOIN tokens
 		ON users.id = tokens.user_id
@@ -204,7 +284,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
@@ -215,6 +295,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Password.hash,
 		&user.Activated,
 		&user.Role,
+		&user.Language,
 		&user.Version,
 	)
 	if err != nil {
@@ -222,9 +303,128 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
 		default:
-			return nil, err
+			return nil, queryError(ctx, err)
 		}
 	}
 
 	return &user, nil
 }
+
+func (m UserModel) ScheduleDeletion(ctx context.Context, userID int64, scheduledFor time.Time) error {
+	query := `
+		INSERT INTO account_deletions (user_id, scheduled_for)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET scheduled_for = EXCLUDED.scheduled_for`
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, query, userID, scheduledFor)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		payload := map[string]any{"id": userID, "scheduled_for": scheduledFor}
+
+		return insertEvent(ctx, tx, EventUserDeletionScheduled, EventAggregateUser, userID, payload)
+	})
+}
+
+// CancelDeletion unschedules the deletion of a user, returning
+// ErrRecordNotFound if none was scheduled.
+func (m UserModel) CancelDeletion(ctx context.Context, userID int64) error {
+	query := `
+		DELETE FROM account_deletions
+		WHERE user_id = $1`
+
+	return withTx(ctx, m.DB, func(tx DBTX) error {
+		ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+		defer cancel()
+
+		result, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return queryError(ctx, err)
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		payload := map[string]any{"id": userID}
+
+		return insertEvent(ctx, tx, EventUserDeletionCancelled, EventAggregateUser, userID, payload)
+	})
+}
+
+// DeleteScheduled removes every user whose deletion grace period is over.
+// Tokens, keys, identities and exports cascade, the JWTs issued to them are
+// revoked, catalogue entries they created are kept but anonymised, and the
+// login history for their email address is dropped as well. A user.deleted
+// event is recorded for each.
+func (m UserModel) DeleteScheduled(ctx context.Context, now time.Time) (int64, error) {
+	query := `
+		WITH deleted AS (
+			DELETE FROM users
+			WHERE id IN (SELECT user_id FROM account_deletions WHERE scheduled_for <= $1)
+			RETURNING id, email
+		), events AS (
+			INSERT INTO events (type, aggregate_type, aggregate_id, payload)
+			SELECT $2, $3, id, jsonb_build_object('id', id) FROM deleted
+		), attempts AS (
+			DELETE FROM login_attempts WHERE email IN (SELECT email FROM deleted)
+		), lockouts AS (
+			DELETE FROM account_lockouts WHERE email IN (SELECT email FROM deleted)
+		), revoked AS (
+			INSERT INTO revoked_tokens (id, expiry)
+			SELECT id, expiry FROM issued_tokens
+			WHERE user_id IN (SELECT id FROM deleted) AND expiry > now()
+			ON CONFLICT (id) DO NOTHING
+		)
+		SELECT count(*) FROM deleted`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var count int64
+
+	err := m.DB.QueryRowContext(ctx, query, now, EventUserDeleted, EventAggregateUser).Scan(&count)
+	return count, queryError(ctx, err)
+}
+
+// DeleteUnactivated removes accounts that were never activated and were
+// created before createdBefore.
+func (m UserModel) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	query := `
+		WITH deleted AS (
+			DELETE FROM users
+			WHERE activated = false AND created_at <= $1
+			RETURNING id, email
+		), events AS (
+			INSERT INTO events (type, aggregate_type, aggregate_id, payload)
+			SELECT $2, $3, id, jsonb_build_object('id', id) FROM deleted
+		), attempts AS (
+			DELETE FROM login_attempts WHERE email IN (SELECT email FROM deleted)
+		), lockouts AS (
+			DELETE FROM account_lockouts WHERE email IN (SELECT email FROM deleted)
+		), revoked AS (
+			INSERT INTO revoked_tokens (id, expiry)
+			SELECT id, expiry FROM issued_tokens
+			WHERE user_id IN (SELECT id FROM deleted) AND expiry > now()
+			ON CONFLICT (id) DO NOTHING
+		)
+		SELECT count(*) FROM deleted`
+
+	ctx, cancel := withQueryTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var count int64
+
+	err := m.DB.QueryRowContext(ctx, query, createdBefore, EventUserDeleted, EventAggregateUser).Scan(&count)
+	return count, queryError(ctx, err)
+}
@@ -2,6 +2,7 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
@@ -31,6 +32,22 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel parses the lower case names of the levels, as used in flags.
+func ParseLevel(s string) (Level, error) {
+	switch s {
+	case "info":
+		return LevelInfo, nil
+	case "error":
+		return LevelError, nil
+	case "fatal":
+		return LevelFatal, nil
+	case "off":
+		return LevelOff, nil
+	default:
+		return 0, fmt.Errorf("jsonlog: unknown level %q", s)
+	}
+}
+
 type Logger struct {
 	out      io.Writer
 	minLevel Level
@@ -44,6 +61,15 @@ func New(out io.Writer, minLevel Level) *Logger {
 	}
 }
 
+// SetLevel changes the minimum level of entries the logger writes. It is safe
+// to call while the logger is in use.
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.minLevel = level
+}
+
 func (l *Logger) PrintInfo(message string, properties map[string]string) {
 	l.print(LevelInfo, message, properties)
 }
@@ -58,9 +84,14 @@ func (l *Logger) PrintFatal(err error, properties map[string]string) {
 }
 
 func (l *Logger) print(level Level, message string, properties map[string]string) (int, error) {
-	if level < l.minLevel {
+	l.mu.Lock()
+	minLevel := l.minLevel
+	l.mu.Unlock()
+
+	if level < minLevel {
 		return 0, nil
 	}
+
 	aux := struct {
 		Level      string            `json:"level"`
 		Time       string            `json:"time"`
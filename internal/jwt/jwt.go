@@ -0,0 +1,320 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+	ErrUnknownKey   = errors.New("unknown signing key")
+)
+
+type Claims struct {
+	ID        string `json:"jti"`
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	Activated bool   `json:"activated"`
+	Role      string `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c Claims) Expiry() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+type Key struct {
+	ID         string
+	Algorithm  string
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+func (k *Key) canSign() bool {
+	return k.secret != nil || k.privateKey != nil
+}
+
+func NewHS256Key(id string, secret []byte) (*Key, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("jwt: HS256 secret for key %q must be at least 32 bytes long", id)
+	}
+
+	return &Key{ID: id, Algorithm: AlgorithmHS256, secret: secret}, nil
+}
+
+func NewEdDSAKey(id string, privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) (*Key, error) {
+	if privateKey != nil {
+		publicKey = privateKey.Public().(ed25519.PublicKey)
+	}
+
+	if publicKey == nil {
+		return nil, fmt.Errorf("jwt: EdDSA key %q has no key material", id)
+	}
+
+	return &Key{ID: id, Algorithm: AlgorithmEdDSA, privateKey: privateKey, publicKey: publicKey}, nil
+}
+
+// LoadKey reads key material from a file. HS256 keys are raw secrets, EdDSA
+// keys are PEM encoded PKCS #8 private keys or PKIX public keys; a public key
+// can only verify tokens and is meant for keys that are being rotated out.
+func LoadKey(algorithm, id, path string) (*Key, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch algorithm {
+	case AlgorithmHS256:
+		return NewHS256Key(id, []byte(strings.TrimSpace(string(contents))))
+	case AlgorithmEdDSA:
+		block, _ := pem.Decode(contents)
+		if block == nil {
+			return nil, fmt.Errorf("jwt: no PEM data found in %s", path)
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			privateKey, ok := parsed.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt: %s does not contain an Ed25519 private key", path)
+			}
+
+			return NewEdDSAKey(id, privateKey, nil)
+		case "PUBLIC KEY":
+			parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			publicKey, ok := parsed.(ed25519.PublicKey)
+			if !ok {
+				return nil, fmt.Errorf("jwt: %s does not contain an Ed25519 public key", path)
+			}
+
+			return NewEdDSAKey(id, nil, publicKey)
+		default:
+			return nil, fmt.Errorf("jwt: unsupported PEM block %q in %s", block.Type, path)
+		}
+	default:
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", algorithm)
+	}
+}
+
+// KeySet signs with its first key and verifies with any key whose id matches
+// the token's kid header, which lets old keys stay valid during rotation.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: at least one key is required")
+	}
+
+	if !keys[0].canSign() {
+		return nil, fmt.Errorf("jwt: key %q cannot be used for signing", keys[0].ID)
+	}
+
+	ks := &KeySet{
+		signing: keys[0],
+		keys:    make(map[string]*Key),
+	}
+
+	for _, key := range keys {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("jwt: duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	return ks, nil
+}
+
+func NewID() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+func (ks *KeySet) Sign(claims Claims) (string, error) {
+	h := header{
+		Algorithm: ks.signing.Algorithm,
+		Type:      "JWT",
+		KeyID:     ks.signing.ID,
+	}
+
+	headerJSON, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encode(headerJSON) + "." + encode(claimsJSON)
+
+	var signature []byte
+
+	switch ks.signing.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, ks.signing.secret)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case AlgorithmEdDSA:
+		signature = ed25519.Sign(ks.signing.privateKey, []byte(signingInput))
+	}
+
+	return signingInput + "." + encode(signature), nil
+}
+
+func (ks *KeySet) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerJSON, err := decode(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+
+	err = json.Unmarshal(headerJSON, &h)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key := ks.signing
+	if h.KeyID != "" {
+		var found bool
+		if key, found = ks.keys[h.KeyID]; !found {
+			return nil, ErrUnknownKey
+		}
+	}
+
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := decode(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signingInput := []byte(parts[0] + "." + parts[1])
+
+	switch key.Algorithm {
+	case AlgorithmHS256:
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(signingInput)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, ErrInvalidToken
+		}
+	case AlgorithmEdDSA:
+		if !ed25519.Verify(key.publicKey, signingInput, signature) {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	claimsJSON, err := decode(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+
+	err = json.Unmarshal(claimsJSON, &claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if !claims.Expiry().After(time.Now()) {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+// Denylist holds the ids of revoked tokens until they would have expired
+// anyway, so it only ever contains tokens that are still otherwise valid.
+type Denylist struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func NewDenylist() *Denylist {
+	return &Denylist{entries: make(map[string]time.Time)}
+}
+
+func (d *Denylist) Add(id string, expiry time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.entries[id] = expiry
+}
+
+func (d *Denylist) Contains(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	expiry, found := d.entries[id]
+	return found && expiry.After(time.Now())
+}
+
+func (d *Denylist) Replace(entries map[string]time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.entries = entries
+}
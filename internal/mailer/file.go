@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type FileMailer struct {
+	templates *Templates
+	dir       string
+	sender    string
+}
+
+func NewFile(templates *Templates, dir, sender string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{templates: templates, dir: dir, sender: sender}, nil
+}
+
+func (m *FileMailer) Send(recipient, templateFile string, data any) error {
+	rendered, err := m.templates.Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s_%s_%s.eml",
+		time.Now().UTC().Format("20060102T150405.000000000"),
+		strings.TrimSuffix(templateFile, filepath.Ext(templateFile)),
+		sanitizeFilename(recipient),
+	)
+
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = newMailMessage(rendered).WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"greenlight.aslan/internal/jsonlog"
+)
+
+type LogMailer struct {
+	templates *Templates
+	logger    *jsonlog.Logger
+	sender    string
+}
+
+func NewLog(templates *Templates, logger *jsonlog.Logger, sender string) *LogMailer {
+	return &LogMailer{templates: templates, logger: logger, sender: sender}
+}
+
+// Send logs who an email went to and which template it used, leaving out the
+// body: it holds activation and password reset tokens, among other secrets,
+// which don't belong in the logs.
+func (m *LogMailer) Send(recipient, templateFile string, data any) error {
+	rendered, err := m.templates.Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.logger.PrintInfo("email sent", map[string]string{
+		"to":       rendered.To,
+		"template": rendered.Template,
+	})
+
+	return nil
+}
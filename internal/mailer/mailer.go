@@ -1,73 +1,33 @@
 package mailer
 
 import (
-	"bytes"
 	"embed"
-	"github.com/go-mail/mail/v2"
-	"html/template"
-	"log"
-	"time"
 )
 
 //go:embed "templates"
 var templateFS embed.FS
 
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 5 * time.Second
-
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
-	}
+type Message struct {
+	To        string
+	From      string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+	Template  string
+	Data      any
 }
 
-func (m Mailer) Send(recipient, templateFile string, data any) error {
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
-	if err != nil {
-		return err
-	}
-
-	log.Print("OK2")
-
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
-	if err != nil {
-		return err
-	}
-
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
-	if err != nil {
-		return err
-	}
-
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
-	if err != nil {
-		return err
-	}
-
-	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
-
-	for i := 1; i <= 3; i++ {
-		err = m.dialer.DialAndSend(msg)
-		if nil == err {
-			return nil
-		}
-
-		time.Sleep(500 * time.Millisecond)
+// Value looks up key in the template data of a message, which is how tests
+// get hold of activation tokens and other links without parsing bodies.
+func (msg *Message) Value(key string) any {
+	values, ok := msg.Data.(map[string]any)
+	if !ok {
+		return nil
 	}
 
-	return err
+	return values[key]
 }
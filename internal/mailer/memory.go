@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"sync"
+)
+
+type MemoryMailer struct {
+	mu        sync.Mutex
+	templates *Templates
+	sender    string
+	messages  []*Message
+}
+
+func NewMemory(templates *Templates, sender string) *MemoryMailer {
+	return &MemoryMailer{templates: templates, sender: sender}
+}
+
+func (m *MemoryMailer) Send(recipient, templateFile string, data any) error {
+	rendered, err := m.templates.Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, rendered)
+
+	return nil
+}
+
+func (m *MemoryMailer) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]*Message, len(m.messages))
+	copy(messages, m.messages)
+
+	return messages
+}
+
+// Last returns the most recent message sent to recipient with the given
+// template, or nil if there is none.
+func (m *MemoryMailer) Last(recipient, templateFile string) *Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == recipient && m.messages[i].Template == templateFile {
+			return m.messages[i]
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
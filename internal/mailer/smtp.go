@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/go-mail/mail/v2"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SMTPMailer struct {
+	templates *Templates
+	dialer    *mail.Dialer
+	sender    string
+}
+
+func NewSMTP(templates *Templates, host string, port int, username, password, sender string) *SMTPMailer {
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 5 * time.Second
+
+	return &SMTPMailer{
+		templates: templates,
+		dialer:    dialer,
+		sender:    sender,
+	}
+}
+
+func (m *SMTPMailer) Send(recipient, templateFile string, data any) error {
+	rendered, err := m.templates.Render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	return m.dialer.DialAndSend(newMailMessage(rendered))
+}
+
+func newMailMessage(rendered *Message) *mail.Message {
+	msg := mail.NewMessage()
+	msg.SetHeader("To", rendered.To)
+	msg.SetHeader("From", rendered.From)
+	msg.SetHeader("Subject", rendered.Subject)
+	msg.SetBody("text/plain", rendered.PlainBody)
+	msg.AddAlternative("text/html", rendered.HTMLBody)
+
+	return msg
+}
+
+// Ping connects and authenticates to the SMTP server without sending
+// anything, to check that mail can be delivered. The whole exchange has to
+// finish within the dialer timeout and by the deadline of ctx. It talks to
+// the server itself rather than through go-mail, which only sets a deadline
+// once the server has greeted, and would wait forever on one that never does.
+func (m *SMTPMailer) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, m.dialer.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.dialer.Host, strconv.Itoa(m.dialer.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	tlsConfig := &tls.Config{ServerName: m.dialer.Host}
+
+	if m.dialer.SSL {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, m.dialer.Host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !m.dialer.SSL {
+		err = c.StartTLS(tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ok, mechanisms := c.Extension("AUTH"); ok && m.dialer.Username != "" {
+		err = c.Auth(m.auth(mechanisms))
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.Quit()
+}
+
+// auth picks the mechanism go-mail would use for the ones the server offers.
+func (m *SMTPMailer) auth(mechanisms string) smtp.Auth {
+	switch {
+	case strings.Contains(mechanisms, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(m.dialer.Username, m.dialer.Password)
+	case strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN"):
+		return &loginAuth{username: m.dialer.Username, password: m.dialer.Password}
+	default:
+		return smtp.PlainAuth("", m.dialer.Username, m.dialer.Password, m.dialer.Host)
+	}
+}
+
+type loginAuth struct {
+	username string
+	password string
+}
+
+// Start goes ahead on unencrypted connections as go-mail does, since the
+// server offered LOGIN on them.
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch {
+	case bytes.Equal(fromServer, []byte("Username:")):
+		return []byte(a.username), nil
+	case bytes.Equal(fromServer, []byte("Password:")):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("mailer: unexpected server challenge: %s", fromServer)
+	}
+}
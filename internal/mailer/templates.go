@@ -0,0 +1,226 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const DefaultLocale = "en"
+
+var ErrTemplateNotFound = errors.New("mailer: template not found")
+
+type TemplateInfo struct {
+	Name    string   `json:"name"`
+	Locales []string `json:"locales"`
+}
+
+// Templates holds every email template parsed once at startup. Files in a
+// layouts directory are shared partials available to all templates, and
+// templates named like user_welcome.ru.tmpl are locale variants of
+// user_welcome.tmpl.
+type Templates struct {
+	templates map[string]*template.Template
+	locales   map[string][]string
+}
+
+// LoadTemplates parses the embedded templates, with any file in overrideDir
+// replacing the embedded file of the same name.
+func LoadTemplates(overrideDir string) (*Templates, error) {
+	embedded, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	pages := map[string]string{}
+	layouts := map[string]string{}
+
+	err = readTemplateFiles(embedded, pages, layouts)
+	if err != nil {
+		return nil, err
+	}
+
+	if overrideDir != "" {
+		err = readTemplateFiles(os.DirFS(overrideDir), pages, layouts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	layoutNames := make([]string, 0, len(layouts))
+	for name := range layouts {
+		layoutNames = append(layoutNames, name)
+	}
+	sort.Strings(layoutNames)
+
+	t := &Templates{
+		templates: map[string]*template.Template{},
+		locales:   map[string][]string{},
+	}
+
+	for name, content := range pages {
+		tmpl := template.New(name)
+
+		for _, layout := range layoutNames {
+			_, err = tmpl.Parse(layouts[layout])
+			if err != nil {
+				return nil, fmt.Errorf("mailer: parsing layout %s: %w", layout, err)
+			}
+		}
+
+		_, err = tmpl.Parse(content)
+		if err != nil {
+			return nil, fmt.Errorf("mailer: parsing template %s: %w", name, err)
+		}
+
+		t.templates[name] = tmpl
+
+		base, locale := splitTemplateName(name)
+		t.locales[base] = append(t.locales[base], locale)
+	}
+
+	for base := range t.locales {
+		sort.Strings(t.locales[base])
+	}
+
+	return t, nil
+}
+
+func readTemplateFiles(fsys fs.FS, pages, layouts map[string]string) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || path.Ext(name) != ".tmpl" {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		if path.Dir(name) == "layouts" {
+			layouts[path.Base(name)] = string(content)
+		} else {
+			pages[path.Base(name)] = string(content)
+		}
+
+		return nil
+	})
+}
+
+// splitTemplateName turns user_welcome.ru.tmpl into ("user_welcome", "ru")
+// and user_welcome.tmpl into ("user_welcome", DefaultLocale).
+func splitTemplateName(name string) (string, string) {
+	base := strings.TrimSuffix(name, ".tmpl")
+
+	i := strings.LastIndex(base, ".")
+	if i == -1 {
+		return base, DefaultLocale
+	}
+
+	return base[:i], base[i+1:]
+}
+
+// Lookup returns the file name of the best variant of templateFile for
+// locale, falling back from "ru-RU" to "ru" and then to templateFile itself.
+func (t *Templates) Lookup(templateFile, locale string) string {
+	base := strings.TrimSuffix(templateFile, ".tmpl")
+	locale = strings.ToLower(locale)
+
+	candidates := []string{locale}
+	if i := strings.IndexAny(locale, "-_"); i != -1 {
+		candidates = append(candidates, locale[:i])
+	}
+
+	for _, candidate := range candidates {
+		if candidate == "" || candidate == DefaultLocale {
+			continue
+		}
+
+		name := fmt.Sprintf("%s.%s.tmpl", base, candidate)
+		if _, ok := t.templates[name]; ok {
+			return name
+		}
+	}
+
+	return templateFile
+}
+
+// Locales returns every locale that has at least one template variant.
+func (t *Templates) Locales() []string {
+	seen := map[string]bool{DefaultLocale: true}
+	locales := []string{DefaultLocale}
+
+	for _, variants := range t.locales {
+		for _, locale := range variants {
+			if !seen[locale] {
+				seen[locale] = true
+				locales = append(locales, locale)
+			}
+		}
+	}
+
+	sort.Strings(locales)
+
+	return locales
+}
+
+func (t *Templates) List() []TemplateInfo {
+	list := make([]TemplateInfo, 0, len(t.locales))
+
+	for base, locales := range t.locales {
+		list = append(list, TemplateInfo{Name: base, Locales: locales})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list
+}
+
+func (t *Templates) Render(sender, recipient, templateFile string, data any) (*Message, error) {
+	tmpl, ok := t.templates[templateFile]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, templateFile)
+	}
+
+	subject := new(bytes.Buffer)
+	err := tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		To:        recipient,
+		From:      sender,
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+		Template:  templateFile,
+		Data:      data,
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,328 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockID is the key of the session-level advisory lock held while migrating,
+// so that instances deployed at the same time take turns.
+const lockID = 7_145_892_301_445
+
+var (
+	ErrDirty          = errors.New("migrate: database is dirty, fix it by hand before migrating")
+	ErrNoChange       = errors.New("migrate: no change")
+	ErrUnknownVersion = errors.New("migrate: unknown version")
+)
+
+var fileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes the state of a database's schema. Version is 0 when no
+// migration has been applied.
+type Status struct {
+	Version int64
+	Dirty   bool
+	Latest  int64
+	Applied []Migration
+	Pending []Migration
+}
+
+// Behind reports whether the database is missing migrations.
+func (s Status) Behind() bool {
+	return s.Version < s.Latest
+}
+
+// Load reads the NNNNNN_name.up.sql and NNNNNN_name.down.sql files in fsys,
+// sorted by version. Every version needs both files.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+
+	for _, entry := range entries {
+		match := fileRX.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version < 1 {
+			return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by both %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := []Migration{}
+
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrate: version %d (%s) needs both an up and a down file", m.Version, m.Name)
+		}
+
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database, keeping track of them in a
+// schema_migrations table laid out the way golang-migrate does it, so
+// databases already managed with that tool carry on where they left off.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest returns the highest version known to the migrator.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	var status Status
+
+	err := m.withConn(ctx, false, func(conn *sql.Conn) error {
+		var err error
+		status, err = m.status(ctx, conn)
+		return err
+	})
+
+	return status, err
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down rolls back the given number of migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if len(status.Applied) == 0 || steps < 1 {
+			return ErrNoChange
+		}
+
+		if steps > len(status.Applied) {
+			steps = len(status.Applied)
+		}
+
+		var target int64
+		if steps < len(status.Applied) {
+			target = status.Applied[len(status.Applied)-steps-1].Version
+		}
+
+		return m.migrate(ctx, conn, status, target)
+	})
+}
+
+// Goto migrates up or down to version, which is 0 or the version of a known
+// migration.
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) < 0 {
+		return fmt.Errorf("%w %d", ErrUnknownVersion, version)
+	}
+
+	return m.withConn(ctx, true, func(conn *sql.Conn) error {
+		status, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		return m.migrate(ctx, conn, status, version)
+	})
+}
+
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn, status Status, target int64) error {
+	if status.Dirty {
+		return fmt.Errorf("%w (version %d)", ErrDirty, status.Version)
+	}
+
+	if target == status.Version {
+		return ErrNoChange
+	}
+
+	if status.Version != 0 && m.find(status.Version) < 0 {
+		return fmt.Errorf("%w %d in the database", ErrUnknownVersion, status.Version)
+	}
+
+	if target > status.Version {
+		for _, migration := range status.Pending {
+			if migration.Version > target {
+				break
+			}
+
+			err := m.apply(ctx, conn, migration.Up, migration.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: applying %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	}
+
+	for i := len(status.Applied) - 1; i >= 0 && status.Applied[i].Version > target; i-- {
+		migration := status.Applied[i]
+
+		var previous int64
+		if i > 0 {
+			previous = status.Applied[i-1].Version
+		}
+
+		err := m.apply(ctx, conn, migration.Down, previous)
+		if err != nil {
+			return fmt.Errorf("migrate: rolling back %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// apply runs one migration script and records the resulting version in the
+// same transaction, so a failed script leaves the database as it was.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, version int64) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_migrations`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if version != 0 {
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)`, version)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) (Status, error) {
+	status := Status{Latest: m.Latest()}
+
+	var exists bool
+
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return Status{}, err
+	}
+
+	if exists {
+		err = conn.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&status.Version, &status.Dirty)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return Status{}, err
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if migration.Version <= status.Version {
+			status.Applied = append(status.Applied, migration)
+		} else {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+
+	return status, nil
+}
+
+// withConn runs fn on a single connection. With lock set, fn runs while
+// holding the advisory lock, and schema_migrations is created if needed.
+func (m *Migrator) withConn(ctx context.Context, lock bool, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if lock {
+		_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID)
+		if err != nil {
+			return err
+		}
+
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+		_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+		if err != nil {
+			return err
+		}
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) find(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
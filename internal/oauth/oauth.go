@@ -0,0 +1,386 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidIDToken = errors.New("invalid id token")
+	ErrMissingSubject = errors.New("identity has no subject")
+
+	// ErrCodeRejected is returned by Exchange when the token endpoint turns
+	// the authorization code down, as it does for codes that are invalid,
+	// expired or already used.
+	ErrCodeRejected = errors.New("authorization code rejected")
+)
+
+type Config struct {
+	Name                  string   `json:"name"`
+	Issuer                string   `json:"issuer"`
+	ClientID              string   `json:"client_id"`
+	ClientSecret          string   `json:"client_secret"`
+	RedirectURL           string   `json:"redirect_url"`
+	Scopes                []string `json:"scopes"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+}
+
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type Provider struct {
+	config Config
+	client *http.Client
+}
+
+// NewProvider fills in any endpoints missing from the config using the
+// issuer's OpenID Connect discovery document.
+func NewProvider(ctx context.Context, cfg Config, client *http.Client) (*Provider, error) {
+	// The issuer is needed even with explicit endpoints, to check the ID
+	// tokens against.
+	if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oauth: provider %q needs a name, issuer, client id and redirect url", cfg.Name)
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	if cfg.AuthorizationEndpoint == "" || cfg.TokenEndpoint == "" {
+		var discovery struct {
+			Issuer                string `json:"issuer"`
+			AuthorizationEndpoint string `json:"authorization_endpoint"`
+			TokenEndpoint         string `json:"token_endpoint"`
+			UserinfoEndpoint      string `json:"userinfo_endpoint"`
+		}
+
+		err := getJSON(ctx, client, strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", "", &discovery)
+		if err != nil {
+			return nil, fmt.Errorf("oauth: discovery for provider %q: %w", cfg.Name, err)
+		}
+
+		if discovery.Issuer != cfg.Issuer {
+			return nil, fmt.Errorf("oauth: provider %q reports issuer %q", cfg.Name, discovery.Issuer)
+		}
+
+		cfg.AuthorizationEndpoint = discovery.AuthorizationEndpoint
+		cfg.TokenEndpoint = discovery.TokenEndpoint
+		if cfg.UserinfoEndpoint == "" {
+			cfg.UserinfoEndpoint = discovery.UserinfoEndpoint
+		}
+	}
+
+	return &Provider{config: cfg, client: client}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(p.config.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return p.config.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange redeems an authorization code and returns the identity from the
+// ID token. The token comes straight from the token endpoint over TLS, so as
+// the OpenID Connect core spec allows, its claims are validated but its
+// signature is not.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+
+	err = doJSON(p.client, req, &tokens)
+	if err != nil {
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.code >= 400 && statusErr.code < 500 {
+			return nil, fmt.Errorf("%w: %s", ErrCodeRejected, statusErr)
+		}
+
+		return nil, fmt.Errorf("oauth: token exchange: %w", err)
+	}
+
+	identity, err := p.parseIDToken(tokens.IDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Email == "" && p.config.UserinfoEndpoint != "" && tokens.AccessToken != "" {
+		var userinfo claims
+
+		err = getJSON(ctx, p.client, p.config.UserinfoEndpoint, tokens.AccessToken, &userinfo)
+		if err != nil {
+			return nil, fmt.Errorf("oauth: userinfo: %w", err)
+		}
+
+		if userinfo.Subject == identity.Subject {
+			identity.Email = userinfo.Email
+			identity.EmailVerified = bool(userinfo.EmailVerified)
+			if identity.Name == "" {
+				identity.Name = userinfo.Name
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+
+	*a = multiple
+	return nil
+}
+
+type flexibleBool bool
+
+func (f *flexibleBool) UnmarshalJSON(b []byte) error {
+	switch strings.Trim(string(b), `"`) {
+	case "true":
+		*f = true
+	default:
+		*f = false
+	}
+	return nil
+}
+
+type claims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      audience     `json:"aud"`
+	ExpiresAt     int64        `json:"exp"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Name          string       `json:"name"`
+}
+
+func (p *Provider) parseIDToken(idToken, nonce string) (*Identity, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	var c claims
+
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	if c.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, c.Issuer)
+	}
+
+	validAudience := false
+	for _, aud := range c.Audience {
+		if aud == p.config.ClientID {
+			validAudience = true
+		}
+	}
+
+	if !validAudience {
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	}
+
+	if time.Unix(c.ExpiresAt, 0).Before(time.Now()) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+
+	if c.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	if c.Subject == "" {
+		return nil, ErrMissingSubject
+	}
+
+	identity := &Identity{
+		Subject:       c.Subject,
+		Email:         c.Email,
+		EmailVerified: bool(c.EmailVerified),
+		Name:          c.Name,
+	}
+
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, endpoint, accessToken string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	return doJSON(client, req, dst)
+}
+
+func doJSON(client *http.Client, req *http.Request, dst any) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1_048_576))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return &statusError{code: res.StatusCode, body: strings.TrimSpace(string(body))}
+	}
+
+	return json.Unmarshal(body, dst)
+}
+
+type statusError struct {
+	code int
+	body string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.code, e.body)
+}
+
+func RandomString() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+func CodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func LoadConfigFile(path string) ([]Config, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []Config
+
+	err = json.Unmarshal(contents, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("oauth: parsing %s: %w", path, err)
+	}
+
+	return configs, nil
+}
+
+// ParseConfig reads a provider from a flag value such as
+// "name=google,issuer=https://accounts.google.com,client-id=...,client-secret=...,redirect-url=...".
+func ParseConfig(value string) (Config, error) {
+	var cfg Config
+
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return Config{}, fmt.Errorf("oauth: invalid provider setting %q", pair)
+		}
+
+		switch key {
+		case "name":
+			cfg.Name = val
+		case "issuer":
+			cfg.Issuer = val
+		case "client-id":
+			cfg.ClientID = val
+		case "client-secret":
+			cfg.ClientSecret = val
+		case "redirect-url":
+			cfg.RedirectURL = val
+		case "scopes":
+			cfg.Scopes = strings.Fields(val)
+		default:
+			return Config{}, fmt.Errorf("oauth: unknown provider setting %q", key)
+		}
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Postgres keeps the buckets in the rate_limits table, so that every
+// instance of the API shares them and they survive restarts. Buckets are
+// refilled and taken from in a single upsert, using the clock of the
+// database so that instances with skewed clocks agree.
+type Postgres struct {
+	db *sql.DB
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (p *Postgres) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	// The update only happens when the refilled bucket has a token to take,
+	// so no row is returned when the request is over the limit.
+	query := `
+		INSERT INTO rate_limits AS b (key, tokens, updated_at)
+		VALUES ($1, $2::double precision - 1, now())
+		ON CONFLICT (key) DO UPDATE
+		SET tokens = LEAST($2::double precision, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::double precision) - 1,
+			updated_at = now()
+		WHERE LEAST($2::double precision, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at) * $3::double precision) >= 1
+		RETURNING tokens`
+
+	var tokens float64
+
+	err := p.db.QueryRowContext(ctx, query, key, limit.Burst, limit.Rate).Scan(&tokens)
+	if err == nil {
+		return newResult(true, tokens, limit), nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Result{}, err
+	}
+
+	query = `
+		SELECT LEAST($2::double precision, tokens + EXTRACT(EPOCH FROM now() - updated_at) * $3::double precision)
+		FROM rate_limits
+		WHERE key = $1`
+
+	err = p.db.QueryRowContext(ctx, query, key, limit.Burst, limit.Rate).Scan(&tokens)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return newResult(false, tokens, limit), nil
+}
+
+func (p *Postgres) Peek(ctx context.Context, key string, limit Limit) (Result, error) {
+	query := `
+		SELECT LEAST($2::double precision, tokens + EXTRACT(EPOCH FROM now() - updated_at) * $3::double precision)
+		FROM rate_limits
+		WHERE key = $1`
+
+	tokens := float64(limit.Burst)
+
+	err := p.db.QueryRowContext(ctx, query, key, limit.Burst, limit.Rate).Scan(&tokens)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Result{}, err
+	}
+
+	return newResult(tokens >= 1, tokens, limit), nil
+}
+
+// DeleteIdle deletes the buckets not used since before. A deleted bucket
+// starts out full the next time it is used.
+func (p *Postgres) DeleteIdle(ctx context.Context, before time.Time) (int64, error) {
+	result, err := p.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
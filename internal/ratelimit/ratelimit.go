@@ -0,0 +1,129 @@
+// Package ratelimit implements token bucket rate limiting, with the buckets
+// kept in process memory or in a store shared by every instance of the API.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Burst requests at once, refilled at Rate requests per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result is the outcome of a request for a token. RetryAfter is the time
+// until the next token is available, and zero while Remaining is positive.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter takes a token from the bucket named by key, or with Peek reports
+// whether it could without taking one. The limit is passed on every call, so
+// that it may change without the buckets being reset.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+	Peek(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// refill returns the tokens in a bucket that held tokens at updated.
+func refill(tokens float64, updated, now time.Time, limit Limit) float64 {
+	elapsed := now.Sub(updated).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	return math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+}
+
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Burst,
+		Remaining: int(math.Floor(math.Max(tokens, 0))),
+	}
+
+	if tokens < 1 && limit.Rate > 0 {
+		result.RetryAfter = time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	}
+
+	return result
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// Memory keeps the buckets in process memory, so every instance of the API
+// enforces its own limits and a restart resets them.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket)}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(m.lastSweep) > time.Minute {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens, b.updated, b.limit = refill(b.tokens, b.updated, now, limit), now, limit
+
+	if b.tokens < 1 {
+		return newResult(false, b.tokens, limit), nil
+	}
+
+	b.tokens--
+
+	return newResult(true, b.tokens, limit), nil
+}
+
+func (m *Memory) Peek(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		return newResult(true, float64(limit.Burst), limit), nil
+	}
+
+	tokens := refill(b.tokens, b.updated, time.Now(), limit)
+
+	return newResult(tokens >= 1, tokens, limit), nil
+}
+
+// sweep drops the buckets that have been idle for longer than it takes them
+// to refill from empty. They would be full by now, the same as a new bucket,
+// so dropping them can't let more requests through.
+func (m *Memory) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if b.limit.Rate > 0 && now.Sub(b.updated).Seconds() > float64(b.limit.Burst)/b.limit.Rate {
+			delete(m.buckets, key)
+		}
+	}
+
+	m.lastSweep = now
+}
@@ -0,0 +1,210 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterExporter writes each span as a line of JSON, for the stdout and
+// file exporters.
+type WriterExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewWriterExporter(out io.Writer) *WriterExporter {
+	return &WriterExporter{out: out}
+}
+
+func (e *WriterExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	var buf bytes.Buffer
+
+	for _, span := range spans {
+		aux := struct {
+			Service      string         `json:"service"`
+			TraceID      string         `json:"trace_id"`
+			SpanID       string         `json:"span_id"`
+			ParentSpanID string         `json:"parent_span_id,omitempty"`
+			Name         string         `json:"name"`
+			Kind         string         `json:"kind"`
+			Start        string         `json:"start"`
+			DurationMS   float64        `json:"duration_ms"`
+			Attributes   map[string]any `json:"attributes,omitempty"`
+			Error        string         `json:"error,omitempty"`
+		}{
+			Service:    service,
+			TraceID:    span.TraceID.String(),
+			SpanID:     span.SpanID.String(),
+			Name:       span.Name,
+			Kind:       span.Kind.String(),
+			Start:      span.Start.UTC().Format(time.RFC3339Nano),
+			DurationMS: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Error:      span.Error,
+		}
+
+		if span.ParentSpanID.IsValid() {
+			aux.ParentSpanID = span.ParentSpanID.String()
+		}
+
+		if len(span.Attributes) > 0 {
+			aux.Attributes = make(map[string]any, len(span.Attributes))
+			for _, attribute := range span.Attributes {
+				aux.Attributes[attribute.Key] = attribute.Value
+			}
+		}
+
+		line, err := json.Marshal(aux)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.out.Write(buf.Bytes())
+	return err
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP over
+// HTTP with the JSON encoding.
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter returns an exporter posting to the /v1/traces path of the
+// collector at endpoint, e.g. http://localhost:4318.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            map[string]any `json:"status,omitempty"`
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, service string, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(service, spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("trace: OTLP export returned status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func otlpRequest(service string, spans []SpanData) map[string]any {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              otlpKind(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+
+		if span.ParentSpanID.IsValid() {
+			s.ParentSpanID = span.ParentSpanID.String()
+		}
+
+		for _, attribute := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute(attribute))
+		}
+
+		if span.Error != "" {
+			s.Status = map[string]any{"code": 2, "message": span.Error}
+		}
+
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	return map[string]any{
+		"resourceSpans": []any{
+			map[string]any{
+				"resource": map[string]any{
+					"attributes": []otlpKeyValue{otlpAttribute(String("service.name", service))},
+				},
+				"scopeSpans": []any{
+					map[string]any{
+						"scope": map[string]any{"name": "greenlight.aslan/internal/trace"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpKind(kind Kind) int {
+	switch kind {
+	case KindServer:
+		return 2
+	case KindClient:
+		return 3
+	default:
+		return 1
+	}
+}
+
+func otlpAttribute(attribute Attribute) otlpKeyValue {
+	var value map[string]any
+
+	switch v := attribute.Value.(type) {
+	case int64:
+		value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case bool:
+		value = map[string]any{"boolValue": v}
+	default:
+		value = map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+
+	return otlpKeyValue{Key: attribute.Key, Value: value}
+}
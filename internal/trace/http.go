@@ -0,0 +1,47 @@
+package trace
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Transport starts a client span for each outgoing request and passes the
+// span on to the receiving service in the traceparent header.
+type Transport struct {
+	Base   http.RoundTripper
+	Tracer *Tracer
+}
+
+func NewTransport(tracer *Tracer, base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &Transport{Base: base, Tracer: tracer}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := t.Tracer.Start(req.Context(), "HTTP "+req.Method, KindClient,
+		String("http.method", req.Method),
+		String("http.url", req.URL.Redacted()),
+	)
+	defer span.End()
+
+	// RoundTrippers must not modify the request they are given.
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+
+	res, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	span.SetAttributes(Int("http.status_code", int64(res.StatusCode)))
+
+	if res.StatusCode >= 500 {
+		span.RecordError(fmt.Errorf("%s", res.Status))
+	}
+
+	return res, nil
+}
@@ -0,0 +1,117 @@
+package trace
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+)
+
+// WrapConnector returns a connector whose connections start a client span
+// for every query, so that SQL shows up in the trace of the request or job
+// that ran it.
+func WrapConnector(connector driver.Connector, tracer *Tracer) driver.Connector {
+	if tracer == nil {
+		return connector
+	}
+
+	return &tracedConnector{Connector: connector, tracer: tracer}
+}
+
+type tracedConnector struct {
+	driver.Connector
+	tracer *Tracer
+}
+
+func (c *tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracedConn{Conn: conn, tracer: c.tracer}, nil
+}
+
+type tracedConn struct {
+	driver.Conn
+	tracer *Tracer
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+
+	return c.Conn.Begin()
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := c.start(ctx, query)
+	defer span.End()
+
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		span.RecordError(err)
+	}
+
+	return result, err
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := c.start(ctx, query)
+	defer span.End()
+
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		span.RecordError(err)
+	}
+
+	return rows, err
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+func (c *tracedConn) start(ctx context.Context, query string) (context.Context, *Span) {
+	statement := strings.Join(strings.Fields(query), " ")
+
+	operation := statement
+	if i := strings.IndexByte(operation, ' '); i > 0 {
+		operation = operation[:i]
+	}
+
+	return c.tracer.Start(ctx, strings.ToUpper(operation), KindClient,
+		String("db.system", "postgresql"),
+		String("db.statement", statement),
+	)
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+
+	return true
+}
@@ -0,0 +1,484 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceparentHeader is the W3C Trace Context header used to propagate spans
+// between services.
+const TraceparentHeader = "traceparent"
+
+var ErrInvalidTraceparent = errors.New("trace: invalid traceparent")
+
+type TraceID [16]byte
+
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies a span and carries the sampling decision made at
+// the root of its trace.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a version 00 traceparent value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Versions other than 00
+// are accepted as long as they start with the same four fields, as the
+// specification asks of parsers.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+
+	if len(version) != 2 || version == "ff" || !isLowerHex(version) {
+		return sc, ErrInvalidTraceparent
+	}
+
+	if version == "00" && len(parts) != 4 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 {
+		return sc, ErrInvalidTraceparent
+	}
+
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return sc, ErrInvalidTraceparent
+	}
+
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(spanID))
+
+	var f [1]byte
+	hex.Decode(f[:], []byte(flags))
+	sc.Sampled = f[0]&0x01 == 1
+
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Extract returns the span context sent in the traceparent header, if there
+// is a valid one.
+func Extract(h http.Header) (SpanContext, bool) {
+	sc, err := ParseTraceparent(h.Get(TraceparentHeader))
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	return sc, true
+}
+
+// Inject sets the traceparent header to the span context of the span in ctx.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		h.Set(TraceparentHeader, sc.Traceparent())
+	}
+}
+
+type contextKey string
+
+const (
+	spanContextKey       = contextKey("span")
+	remoteSpanContextKey = contextKey("remote")
+)
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey, span)
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteSpanContext records a span context received from another
+// service, so that the next span started from ctx becomes its child.
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteSpanContextKey, sc)
+}
+
+// SpanContextFromContext returns the span context of the current span in
+// ctx, falling back to a remote one when no local span has been started.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+
+	sc, _ := ctx.Value(remoteSpanContextKey).(SpanContext)
+	return sc
+}
+
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindServer
+	KindClient
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation within a trace. All of its methods may be called
+// on a nil Span, which is what a nil Tracer hands out.
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+	mu      sync.Mutex
+	data    SpanData
+	ended   bool
+}
+
+// SpanData is the finished state of a span, as handed to exporters.
+type SpanData struct {
+	Name         string
+	Kind         Kind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   []Attribute
+	Error        string
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: s.sampled}
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Name = name
+}
+
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Attributes = append(s.data.Attributes, attributes...)
+}
+
+// RecordError marks the span as failed. Only the last error is kept.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Error = err.Error()
+}
+
+// End finishes the span and queues it for export if its trace is sampled.
+// Calls after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = append([]Attribute(nil), s.data.Attributes...)
+
+	s.mu.Unlock()
+
+	if s.sampled {
+		s.tracer.export(data)
+	}
+}
+
+const (
+	exportBatchSize = 512
+	exportQueueSize = 4096
+	exportInterval  = 5 * time.Second
+)
+
+// Exporter sends finished spans somewhere they can be looked at.
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []SpanData) error
+}
+
+// Tracer starts spans and exports the sampled ones in batches from a
+// background goroutine. A nil Tracer is valid and traces nothing.
+type Tracer struct {
+	service  string
+	ratio    float64
+	exporter Exporter
+	onError  func(error)
+
+	queue    chan SpanData
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewTracer returns a Tracer that samples the given ratio of new traces.
+// Traces continued from a remote parent keep the parent's decision. The
+// exporter may be nil, in which case spans still get IDs for propagation and
+// logging but are not exported. Export errors are passed to onError.
+func NewTracer(service string, ratio float64, exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		service:  service,
+		ratio:    ratio,
+		exporter: exporter,
+		onError:  onError,
+		queue:    make(chan SpanData, exportQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if exporter == nil {
+		close(t.done)
+		return t
+	}
+
+	go t.run()
+
+	return t
+}
+
+// Start begins a span as a child of the span, or remote span context, in
+// ctx and returns a context carrying the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, attributes ...Attribute) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Kind:       kind,
+			SpanID:     newSpanID(),
+			Start:      time.Now(),
+			Attributes: attributes,
+		},
+	}
+
+	if parent.IsValid() {
+		span.data.TraceID = parent.TraceID
+		span.data.ParentSpanID = parent.SpanID
+		span.sampled = parent.Sampled
+	} else {
+		span.data.TraceID = newTraceID()
+		span.sampled = t.sample(span.data.TraceID)
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// sample makes the decision for a new trace from the trace ID itself,
+// so that every service sampling the same ratio agrees on it.
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.ratio >= 1:
+		return true
+	case t.ratio <= 0:
+		return false
+	}
+
+	x := binary.BigEndian.Uint64(id[8:]) >> 1
+	return x < uint64(t.ratio*(1<<63))
+}
+
+func (t *Tracer) export(data SpanData) {
+	if t.exporter == nil {
+		return
+	}
+
+	select {
+	case t.queue <- data:
+	default:
+		// The queue is full, so the exporter is falling behind. Dropping
+		// spans is better than holding up requests.
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, exportBatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		err := t.exporter.Export(ctx, t.service, batch)
+		if err != nil && t.onError != nil {
+			t.onError(err)
+		}
+
+		batch = make([]SpanData, 0, exportBatchSize)
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			batch = append(batch, data)
+			if len(batch) >= exportBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case data := <-t.queue:
+					batch = append(batch, data)
+					if len(batch) >= exportBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Shutdown exports the spans still queued and stops the background
+// goroutine, giving up when ctx is done.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.stopOnce.Do(func() { close(t.stop) })
+
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
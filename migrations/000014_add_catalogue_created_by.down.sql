@@ -0,0 +1,3 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS created_by;
+ALTER TABLE directors DROP COLUMN IF EXISTS created_by;
+ALTER TABLE trailers DROP COLUMN IF EXISTS created_by;
@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE directors ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE trailers ADD COLUMN IF NOT EXISTS created_by bigint REFERENCES users ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS account_deletions;
+DROP TABLE IF EXISTS data_exports;
\ No newline at end of file
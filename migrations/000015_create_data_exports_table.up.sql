@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    archive bytea NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id bigint PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    scheduled_for timestamp(0) with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    payload jsonb NOT NULL DEFAULT '{}',
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL,
+    last_error text NOT NULL DEFAULT '',
+    unique_key text UNIQUE,
+    run_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    locked_until timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    finished_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (locked_until) WHERE status = 'running';
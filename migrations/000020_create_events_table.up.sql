@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS events (
+    id bigserial PRIMARY KEY,
+    type text NOT NULL,
+    aggregate_type text NOT NULL,
+    aggregate_id bigint NOT NULL,
+    payload jsonb NOT NULL,
+    txid xid8 NOT NULL DEFAULT pg_current_xact_id(),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS events_aggregate_idx ON events (aggregate_type, aggregate_id);
@@ -0,0 +1,10 @@
+CREATE OR REPLACE FUNCTION notify_event() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('events', NEW.aggregate_type);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER events_notify
+AFTER INSERT ON events
+FOR EACH ROW EXECUTE FUNCTION notify_event();
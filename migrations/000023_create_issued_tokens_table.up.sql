@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS issued_tokens (
+    id text PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS issued_tokens_user_id_idx ON issued_tokens (user_id);
@@ -0,0 +1,2 @@
+-- The scrubbed payloads can't be restored.
+SELECT 1;
@@ -0,0 +1,3 @@
+UPDATE events
+SET payload = jsonb_build_object('id', aggregate_id)
+WHERE aggregate_type = 'user' AND type IN ('user.created', 'user.updated', 'user.activated');
@@ -0,0 +1,2 @@
+-- The random passwords can't be restored.
+SELECT 1;
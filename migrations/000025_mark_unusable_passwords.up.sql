@@ -0,0 +1,11 @@
+-- Accounts registered through an identity provider were given a random
+-- password that nobody knows. They are the ones whose identity was linked as
+-- they were created, and their password is marked unusable, so that deleting
+-- them is confirmed by email instead.
+UPDATE users
+SET password_hash = ''::bytea
+WHERE EXISTS (
+    SELECT 1 FROM user_identities
+    WHERE user_identities.user_id = users.id
+    AND user_identities.created_at - users.created_at < interval '1 minute'
+);
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations so the binary can bring a
+// database up to date on its own.
+package migrations
+
+import (
+	"embed"
+)
+
+//go:embed *.sql
+var FS embed.FS